@@ -17,8 +17,11 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
+	"strings"
+	"time"
 
 	"github.com/booster-proj/booster/core"
 	"github.com/booster-proj/booster/dialer"
@@ -39,6 +42,37 @@ var (
 
 	// API configuration
 	apiPort int
+
+	// Balancer configuration
+	strategy     string
+	strategyOpts []string
+
+	// Dialer configuration
+	parkTimeout time.Duration
+	maxParked   int
+	keepAlive   time.Duration
+
+	// Listener configuration
+	pollMaxInterval time.Duration
+	sourcesFile     string
+	checkTargets    []string
+	checkLevel      string
+	checkSources    []string
+	checkIntervals  []string
+	checkInterval   time.Duration
+	checkParallel   int
+	dialErrLimit    int
+	dialErrWindow   time.Duration
+
+	// Policies configuration
+	churnLimit    int
+	churnWindow   time.Duration
+	churnCoolDown time.Duration
+	pinsFile      string
+
+	// Metrics configuration
+	textfilePath     string
+	textfileInterval time.Duration
 )
 
 // serverCmd represents the server command
@@ -51,19 +85,64 @@ var serverCmd = &cobra.Command{
 			log.Fatal(err)
 		}
 
-		b := new(core.Balancer)
+		opts, err := makeStrategyOptions()
+		if err != nil {
+			log.Fatal(err)
+		}
+		st, err := core.NewStrategy(strategy, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		strategyEvents := make(chan core.StrategyEvent, 64)
+		b := &core.Balancer{Strategy: st, Events: strategyEvents}
+
+		var adaptivePoll *source.AdaptiveInterval
+		if pollMaxInterval > source.PollInterval {
+			adaptivePoll = &source.AdaptiveInterval{
+				Min: source.PollInterval,
+				Max: pollMaxInterval,
+			}
+		}
+
+		checkConf, err := makeCheckConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+
 		rs := store.New(b)
+		if churnLimit > 0 {
+			rs.SetChurnLimiter(&store.ChurnLimiter{
+				Window:   churnWindow,
+				Hard:     churnLimit,
+				CoolDown: churnCoolDown,
+			})
+		}
+		if pinsFile != "" {
+			if err := rs.SetPinsFile(pinsFile); err != nil {
+				log.Fatal(err)
+			}
+		}
 		exp := new(metrics.Exporter)
 		l := source.NewListener(source.Config{
-			Store:           rs,
-			MetricsExporter: exp,
+			Store:            rs,
+			MetricsExporter:  exp,
+			KeepAlive:        keepAlive,
+			AdaptivePoll:     adaptivePoll,
+			SourcesFile:      sourcesFile,
+			Check:            checkConf,
+			HookErrThreshold: dialErrLimit,
+			HookErrWindow:    dialErrWindow,
 		})
 		d := dialer.New(rs)
+		d.ParkTimeout = parkTimeout
+		d.MaxParked = maxParked
 		d.SetMetricsExporter(exp)
 
 		router := remote.NewRouter()
 		router.Store = rs
 		router.MetricsProvider = exp
+		router.Health = l
 		router.Info = remote.BoosterInfo{
 			Version:   Version,
 			Commit:    Commit,
@@ -112,6 +191,29 @@ var serverCmd = &cobra.Command{
 			return r.ListenAndServe(ctx, apiPort)
 		})
 
+		g.Go(func() error {
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case e := <-strategyEvents:
+					exp.IncStrategyFallback(map[string]string{"cause": e.Cause})
+				}
+			}
+		})
+
+		if textfilePath != "" {
+			w := &metrics.TextfileWriter{
+				Path:     textfilePath,
+				Interval: textfileInterval,
+			}
+			g.Go(func() error {
+				log.Info.Printf("Writing metrics to %s every %v", textfilePath, textfileInterval)
+				defer log.Info.Print("Metrics textfile writer stopped.")
+				return w.Run(ctx)
+			})
+		}
+
 		if err := g.Wait(); err != nil {
 			log.Fatal(err)
 		}
@@ -126,6 +228,37 @@ func init() {
 
 	// API configuration
 	serverCmd.Flags().IntVar(&apiPort, "api-port", 7764, "API server listening port")
+
+	// Balancer configuration
+	serverCmd.Flags().StringVar(&strategy, "strategy", "roundrobin", "Name of the strategy used to choose the source of each connection")
+	serverCmd.Flags().StringSliceVar(&strategyOpts, "strategy-option", nil, "Option of the strategy, as key=value, can be repeated")
+
+	// Dialer configuration
+	serverCmd.Flags().DurationVar(&parkTimeout, "park-timeout", 0, "Maximum time a connection waits for a source when none is available, 0 to fail immediately")
+	serverCmd.Flags().DurationVar(&keepAlive, "keepalive", 0, "Interval between TCP keep-alive probes on the connections dialed, 0 for the system default, negative to disable them")
+	serverCmd.Flags().IntVar(&maxParked, "max-parked", 256, "Maximum number of connections waiting for a source at the same time, 0 for no limit")
+
+	// Listener configuration
+	serverCmd.Flags().DurationVar(&pollMaxInterval, "poll-max-interval", 0, "If greater than the default poll interval, the interval between source polls doubles while nothing changes, up to this value")
+	serverCmd.Flags().StringSliceVar(&checkTargets, "check-target", nil, "Host:port address or http(s) URL used to check the connection of the sources, can be repeated")
+	serverCmd.Flags().StringVar(&checkLevel, "check-confidence", "high", "Confidence level of the source checks: low (the interface has an address), medium (connect to a target) or high (request a target)")
+	serverCmd.Flags().StringSliceVar(&checkSources, "check-source-confidence", nil, "Confidence level of the checks of a single source, as id=level, can be repeated")
+	serverCmd.Flags().DurationVar(&checkInterval, "check-interval", 0, "How often the stored sources are checked again, 0 to check them only after dial errors")
+	serverCmd.Flags().IntVar(&checkParallel, "check-concurrency", source.DefaultCheckConcurrency, "Maximum number of source checks running at the same time")
+	serverCmd.Flags().StringSliceVar(&checkIntervals, "check-source-interval", nil, "How often a stored source is checked again, as id=duration, can be repeated")
+	serverCmd.Flags().IntVar(&dialErrLimit, "dial-error-threshold", 1, "Number of dial errors a source must produce within the dial error window before it is checked again, and removed if the check fails")
+	serverCmd.Flags().DurationVar(&dialErrWindow, "dial-error-window", 30*time.Second, "Window over which the dial errors of a source are counted")
+	serverCmd.Flags().StringVar(&sourcesFile, "sources-file", "", "JSON file declaring the sources to use, in place of the network interfaces found")
+
+	// Policies configuration
+	serverCmd.Flags().IntVar(&churnLimit, "policy-churn-limit", 0, "Maximum number of policy changes an issuer can perform within the churn window, 0 for no limit")
+	serverCmd.Flags().DurationVar(&churnWindow, "policy-churn-window", time.Minute, "Window over which policy changes are counted")
+	serverCmd.Flags().DurationVar(&churnCoolDown, "policy-churn-cooldown", 5*time.Minute, "How long an issuer that exceeds the policy churn limit cannot change policies")
+	serverCmd.Flags().StringVar(&pinsFile, "pins-file", "", "JSON file where the sticky policy pins are saved, so that they survive restarts")
+
+	// Metrics configuration
+	serverCmd.Flags().StringVar(&textfilePath, "metrics-textfile", "", "If set, periodically write the metrics to this file, for the node_exporter textfile collector")
+	serverCmd.Flags().DurationVar(&textfileInterval, "metrics-textfile-interval", 15*time.Second, "Interval between each write of the metrics textfile")
 }
 
 func captureSignals(cancel context.CancelFunc) {
@@ -138,3 +271,54 @@ func captureSignals(cancel context.CancelFunc) {
 		}
 	}()
 }
+
+// makeStrategyOptions builds the strategy options from the flags.
+func makeStrategyOptions() (core.StrategyOptions, error) {
+	opts := make(core.StrategyOptions, len(strategyOpts))
+	for _, v := range strategyOpts {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid strategy option %q: expected key=value", v)
+		}
+		opts[kv[0]] = kv[1]
+	}
+	return opts, nil
+}
+
+// makeCheckConfig builds the checks configuration from the flags.
+func makeCheckConfig() (*source.CheckConfig, error) {
+	level, err := source.ParseConfidence(checkLevel)
+	if err != nil {
+		return nil, err
+	}
+	c := &source.CheckConfig{
+		Targets:     checkTargets,
+		Confidence:  level,
+		Sources:     make(map[string]source.Confidence, len(checkSources)),
+		Interval:    checkInterval,
+		Intervals:   make(map[string]time.Duration, len(checkIntervals)),
+		Concurrency: checkParallel,
+	}
+	for _, v := range checkSources {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid source confidence %q: expected id=level", v)
+		}
+		if c.Sources[kv[0]], err = source.ParseConfidence(kv[1]); err != nil {
+			return nil, err
+		}
+	}
+	for _, v := range checkIntervals {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid source check interval %q: expected id=duration", v)
+		}
+		if c.Intervals[kv[0]], err = time.ParseDuration(kv[1]); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -18,10 +18,15 @@
 package core
 
 import (
+	"container/ring"
 	"context"
 	"errors"
+	"fmt"
 	"net"
 	"sync"
+	"time"
+
+	"upspin.io/log"
 )
 
 // Dialer is a wrapper around the DialContext function.
@@ -43,13 +48,25 @@ type Source interface {
 	Close() error
 }
 
-// Strategy chooses a source from a ring of sources.
-type Strategy func(ctx context.Context, r *Ring) (Source, error)
+// DefaultPickTimeout is the PickTimeout used by a Balancer when none
+// is set.
+const DefaultPickTimeout = 10 * time.Millisecond
+
+// Causes of a StrategyEvent.
+const (
+	FallbackPanic   = "panic"
+	FallbackTimeout = "timeout"
+	FallbackInvalid = "invalid"
+)
 
-// RoundRobin is a naive strategy that iterates and returns each element contained in r.
-func RoundRobin(ctx context.Context, r *Ring) (Source, error) {
-	defer r.Next()
-	return r.Source(), nil
+// StrategyEvent is emitted by a Balancer each time its Strategy
+// misbehaves and it is replaced by RoundRobin for one pick.
+type StrategyEvent struct {
+	// Cause is one of FallbackPanic, FallbackTimeout and FallbackInvalid.
+	Cause  string
+	Reason string
+	Flow   FlowDescriptor
+	At     time.Time
 }
 
 // Balancer distributes work to set of sources, using a particular strategy.
@@ -60,10 +77,25 @@ type Balancer struct {
 	r   *Ring
 
 	Strategy
+
+	// PickTimeout is the maximum amount of time Strategy can take to
+	// pick a source. Zero means DefaultPickTimeout, while a negative
+	// value disables the timeout, running Strategy on the goroutine of
+	// the caller of Get.
+	PickTimeout time.Duration
+	// Events, if set, receives a StrategyEvent each time Strategy is
+	// replaced by RoundRobin. Events are dropped when the channel is not
+	// ready to receive them.
+	Events chan<- StrategyEvent
+
+	// stalled, if set, delivers the result of a pick that timed out.
+	// Strategy is not called again until it does.
+	stalled <-chan pickResult
 }
 
 // Get returns a Source from the balancer's source list using the predefined Strategy.
-// If no Strategy was provided, Get returns a Source using RoundRobin.
+// If no Strategy was provided, Get returns a Source using RoundRobin. The
+// FlowDescriptor carried by `ctx`, see WithFlow, is passed to the Strategy.
 func (b *Balancer) Get(ctx context.Context, blacklist ...Source) (Source, error) {
 	b.mux.Lock()
 	defer b.mux.Unlock()
@@ -74,29 +106,163 @@ func (b *Balancer) Get(ctx context.Context, blacklist ...Source) (Source, error)
 	if b.Strategy == nil {
 		b.Strategy = RoundRobin
 	}
-	if len(blacklist) == 0 {
-		return b.Strategy(ctx, b.r)
+
+	var bl map[string]struct{}
+	if len(blacklist) > 0 {
+		bl = make(map[string]struct{}, len(blacklist))
+		for _, v := range blacklist {
+			bl[v.ID()] = struct{}{}
+		}
+	}
+
+	// Collect the candidates in ring order, starting from the current
+	// position.
+	n := b.r.Len()
+	candidates := make([]SourceRecord, 0, n)
+	elems := make([]*ring.Ring, 0, n)
+	for i, e := 0, b.r.Ring; i < n; i, e = i+1, e.Next() {
+		src, ok := e.Value.(Source)
+		if !ok {
+			continue
+		}
+		if _, ok := bl[src.ID()]; ok {
+			continue
+		}
+		candidates = append(candidates, newSourceRecord(src))
+		elems = append(elems, e)
+	}
+	if len(candidates) == 0 {
+		return nil, errors.New("balancer: unable to find any suitable source")
+	}
+
+	i, err := b.pick(ctx, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	// The ring points to the source that follows the one picked.
+	b.r.Ring = elems[i].Next()
+	return elems[i].Value.(Source), nil
+}
+
+// Report forwards `o` to the balancer's Strategy.
+func (b *Balancer) Report(o Outcome) {
+	b.mux.Lock()
+	s := b.Strategy
+	b.mux.Unlock()
+
+	if s == nil {
+		return
 	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error.Printf("balancer: strategy panicked handling outcome of %v: %v", o.SourceID, r)
+		}
+	}()
+	s.Report(o)
+}
+
+type pickResult struct {
+	id  string
+	err error
+	// cause and reason are set when the strategy misbehaved.
+	cause, reason string
+}
 
-	bl := make(map[string]interface{})
-	for _, v := range blacklist {
-		bl[v.ID()] = nil
+// pick returns the index of the candidate chosen by the balancer's
+// Strategy, falling back to RoundRobin when the strategy misbehaves, i.e.
+// it panics, it takes longer than PickTimeout or it returns neither a
+// candidate nor an error. Dials should not fail because of a broken
+// strategy.
+func (b *Balancer) pick(ctx context.Context, candidates []SourceRecord) (int, error) {
+	flow := FlowFromContext(ctx)
+	res := b.callStrategy(ctx, candidates, flow)
+	if res.cause == "" {
+		if res.err != nil {
+			return -1, res.err
+		}
+		for i, v := range candidates {
+			if v.ID == res.id {
+				return i, nil
+			}
+		}
+		res.cause = FallbackInvalid
+		res.reason = fmt.Sprintf("strategy returned %q, which is not a candidate", res.id)
 	}
 
-	for i := 0; i < b.r.Len(); i++ {
-		s, err := b.Strategy(ctx, b.r)
-		if err != nil {
-			// Avoid retring if the strategy returns an error.
-			return nil, err
+	b.fallback(res, flow)
+	return 0, nil // RoundRobin picks the first candidate.
+}
+
+func (b *Balancer) callStrategy(ctx context.Context, candidates []SourceRecord, flow FlowDescriptor) pickResult {
+	// RoundRobin is known to behave.
+	if _, ok := b.Strategy.(roundRobin); ok || b.PickTimeout < 0 {
+		return pickWith(ctx, b.Strategy, candidates, flow)
+	}
+	if b.stalled != nil {
+		select {
+		case <-b.stalled:
+			b.stalled = nil
+		default:
+			return pickResult{cause: FallbackTimeout, reason: "strategy still running a previous pick"}
 		}
+	}
 
-		// Check if the source is contained in the blacklist.
-		if _, ok := bl[s.ID()]; !ok {
-			return s, nil
+	timeout := b.PickTimeout
+	if timeout == 0 {
+		timeout = DefaultPickTimeout
+	}
+	c := make(chan pickResult, 1)
+	s := b.Strategy
+	go func() {
+		c <- pickWith(ctx, s, candidates, flow)
+	}()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case res := <-c:
+		return res
+	case <-t.C:
+		b.stalled = c
+		return pickResult{cause: FallbackTimeout, reason: fmt.Sprintf("strategy did not pick a source within %v", timeout)}
+	}
+}
+
+func pickWith(ctx context.Context, s Strategy, candidates []SourceRecord, flow FlowDescriptor) (res pickResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			res = pickResult{cause: FallbackPanic, reason: fmt.Sprintf("strategy panicked: %v", r)}
 		}
+	}()
+
+	res.id, res.err = s.Pick(ctx, candidates, flow)
+	return
+}
+
+func (b *Balancer) fallback(res pickResult, flow FlowDescriptor) {
+	log.Error.Printf("balancer: %s, falling back to round robin", res.reason)
+	if b.Events == nil {
+		return
+	}
+	select {
+	case b.Events <- StrategyEvent{Cause: res.cause, Reason: res.reason, Flow: flow, At: time.Now()}:
+	default:
 	}
+}
 
-	return nil, errors.New("balancer: unable to find any suitable source")
+// newSourceRecord describes `src` to the strategies.
+func newSourceRecord(src Source) SourceRecord {
+	rec := SourceRecord{ID: src.ID(), OpenConns: -1}
+	if c, ok := src.(interface{ Len() int }); ok {
+		rec.OpenConns = c.Len()
+	}
+	if c, ok := src.(interface {
+		LastCheck() (time.Time, time.Duration)
+	}); ok {
+		rec.LastCheck, rec.CheckLatency = c.LastCheck()
+	}
+	return rec
 }
 
 // Put adds ss as sources to the current balancer ring. If ss.len() == 0, Put silently returns,
@@ -164,6 +330,45 @@ func (b *Balancer) Del(ss ...Source) {
 	b.r = s
 }
 
+// ReplaceAll makes ss the set of sources stored by the balancer, in one
+// single step: at no point in time the balancer is observed holding only
+// part of the old or new set.
+// Sources that are already stored are kept, preserving their order and the
+// balancer's ring position. Stored sources that are not contained in ss are
+// closed and removed, while the new ones are added as "tail".
+func (b *Balancer) ReplaceAll(ss ...Source) {
+	// Create a map of the sources that have to be stored (lookup O(1))
+	m := make(map[string]Source, len(ss))
+	for _, v := range ss {
+		m[v.ID()] = v
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	l := make([]Source, 0, len(ss))
+	if b.r != nil {
+		b.r.Do(func(s Source) {
+			if _, ok := m[s.ID()]; ok {
+				// Keep the stored instance.
+				l = append(l, s)
+				delete(m, s.ID())
+			} else {
+				// This source will be removed.
+				s.Close()
+			}
+		})
+	}
+	for _, v := range ss {
+		if _, ok := m[v.ID()]; ok {
+			l = append(l, v)
+			delete(m, v.ID())
+		}
+	}
+
+	b.r = NewRingSources(l...)
+}
+
 // Do executes f on each source stored in the balancer.
 func (b *Balancer) Do(f func(Source)) {
 	b.mux.Lock()
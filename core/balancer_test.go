@@ -18,6 +18,7 @@ package core_test
 import (
 	"context"
 	"net"
+	"sync"
 	"testing"
 	"time"
 
@@ -187,3 +188,90 @@ func TestDel(t *testing.T) {
 		t.Fatal("closeHook was not called")
 	}
 }
+
+func TestReplaceAll(t *testing.T) {
+	b := &core.Balancer{}
+
+	s0 := newMock("s0")
+	s1 := newMock("s1")
+	b.Put(s0, s1)
+
+	c := make(chan bool, 1)
+	s0.closeHook = func() {
+		t.Log("closeHook() called")
+		c <- true
+	}
+
+	// s1 is already stored: the stored instance has to be kept.
+	b.ReplaceAll(newMock("s1"), newMock("s2"))
+
+	if b.Len() != 2 {
+		t.Fatalf("Unexpected balancer Len after ReplaceAll: wanted 2, found %v", b.Len())
+	}
+
+	tt := []string{"s1", "s2"}
+	i := 0
+	b.Do(func(s core.Source) {
+		if s.ID() != tt[i] {
+			t.Fatalf("%d: Unexpected source ID: wanted %v, found %v", i, tt[i], s.ID())
+		}
+		if s.ID() == "s1" && s != s1 {
+			t.Fatalf("%d: Stored instance of %v was replaced", i, s.ID())
+		}
+		i++
+	})
+
+	select {
+	case <-c:
+	case <-time.After(time.Millisecond):
+		t.Fatal("closeHook was not called")
+	}
+}
+
+// Switching between two non empty sets of sources must never leave
+// the balancer empty.
+func TestReplaceAll_neverEmpty(t *testing.T) {
+	b := &core.Balancer{}
+	set0 := []core.Source{newMock("s0"), newMock("s1")}
+	set1 := []core.Source{newMock("s2"), newMock("s3")}
+	b.Put(set0...)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			if i%2 == 0 {
+				b.ReplaceAll(set1...)
+			} else {
+				b.ReplaceAll(set0...)
+			}
+		}
+	}()
+
+	errc := make(chan error, 1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if _, err := b.Get(context.TODO()); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	select {
+	case err := <-errc:
+		t.Fatalf("Unexpected error while getting source: %v", err)
+	default:
+	}
+}
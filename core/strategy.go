@@ -0,0 +1,193 @@
+// Copyright © 2019 KIM KeepInMind GmbH/srl
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SourceRecord describes a source that a Strategy can pick.
+type SourceRecord struct {
+	ID string
+	// OpenConns is the number of connections open through the source,
+	// or -1 if the source is not able to report it.
+	OpenConns int
+	// LastCheck is when the connection of the source was last checked
+	// successfully, zero if unknown, and CheckLatency how long that
+	// check took.
+	LastCheck    time.Time
+	CheckLatency time.Duration
+}
+
+// FlowDescriptor describes the connection a source is picked for.
+type FlowDescriptor struct {
+	Network string
+	// Target is the address dialed, usually in the "host:port" form.
+	Target string
+	// Attempt is the number of sources already tried for this
+	// connection.
+	Attempt int
+}
+
+type flowKey struct{}
+
+// WithFlow returns a copy of `ctx` carrying `flow`, which the Balancer
+// passes to its Strategy.
+func WithFlow(ctx context.Context, flow FlowDescriptor) context.Context {
+	return context.WithValue(ctx, flowKey{}, flow)
+}
+
+// FlowFromContext returns the FlowDescriptor carried by `ctx`, if any.
+func FlowFromContext(ctx context.Context) FlowDescriptor {
+	flow, _ := ctx.Value(flowKey{}).(FlowDescriptor)
+	return flow
+}
+
+// Outcome tells a Strategy what happened to a connection dialed through
+// the source it picked.
+type Outcome struct {
+	SourceID string
+	Flow     FlowDescriptor
+	// Err is the dial error, nil if the connection was established.
+	Err error
+	// DialTime is how long dialing took.
+	DialTime time.Duration
+	// Closed is set when the outcome reports the end of a connection,
+	// in which case Sent and Received are the bytes transferred during
+	// Duration.
+	Closed   bool
+	Sent     int64
+	Received int64
+	Duration time.Duration
+}
+
+// Strategy chooses the source to use for each connection.
+//
+// Pick is called by the Balancer while holding its lock, on the path of
+// every dial: it must return quickly, without blocking nor performing
+// I/O, and it must not retain `candidates`. `candidates` is never empty
+// and only contains the sources that can be used for `flow`, in ring
+// order; Pick returns the identifier of one of them. A strategy that
+// panics, that does not return within the PickTimeout of the Balancer,
+// or that returns neither a candidate nor an error, is replaced by
+// RoundRobin for that pick.
+//
+// Report receives, possibly concurrently, the outcome of the connections
+// dialed through the sources picked, and must not block either.
+//
+// testutil.TestStrategy checks these requirements.
+type Strategy interface {
+	Pick(ctx context.Context, candidates []SourceRecord, flow FlowDescriptor) (sourceID string, err error)
+	Report(outcome Outcome)
+}
+
+// StrategyFunc adapts a function to a Strategy that ignores the
+// outcomes reported.
+type StrategyFunc func(ctx context.Context, candidates []SourceRecord, flow FlowDescriptor) (string, error)
+
+// Pick implements Strategy.
+func (f StrategyFunc) Pick(ctx context.Context, candidates []SourceRecord, flow FlowDescriptor) (string, error) {
+	return f(ctx, candidates, flow)
+}
+
+// Report implements Strategy.
+func (f StrategyFunc) Report(Outcome) {}
+
+// RoundRobin is a naive strategy that picks each source in turn. It
+// relies on the Balancer, which provides the candidates starting from
+// the source that follows the last one picked.
+var RoundRobin Strategy = roundRobin{}
+
+type roundRobin struct{}
+
+func (roundRobin) Pick(ctx context.Context, candidates []SourceRecord, flow FlowDescriptor) (string, error) {
+	if len(candidates) == 0 {
+		return "", errors.New("core: no candidate sources")
+	}
+	return candidates[0].ID, nil
+}
+
+func (roundRobin) Report(Outcome) {}
+
+// StrategyOptions are the strategy specific options, as key value pairs.
+type StrategyOptions map[string]string
+
+// StrategyFactory creates a Strategy configured with `opts`. It returns
+// an error if any option is unknown or invalid.
+type StrategyFactory func(opts StrategyOptions) (Strategy, error)
+
+var strategies = struct {
+	sync.Mutex
+	m map[string]StrategyFactory
+}{
+	m: map[string]StrategyFactory{
+		"roundrobin": func(opts StrategyOptions) (Strategy, error) {
+			if len(opts) > 0 {
+				return nil, fmt.Errorf("core: strategy roundrobin does not accept options")
+			}
+			return RoundRobin, nil
+		},
+	},
+}
+
+// RegisterStrategy makes the strategies created by `f` available with
+// `name`, so that they can be selected at configuration time. It is meant
+// to be called from init functions, and it panics if `f` is nil or if a
+// strategy with the same name has already been registered.
+func RegisterStrategy(name string, f StrategyFactory) {
+	strategies.Lock()
+	defer strategies.Unlock()
+
+	if f == nil {
+		panic("core: RegisterStrategy called with nil factory for strategy " + name)
+	}
+	if _, ok := strategies.m[name]; ok {
+		panic("core: RegisterStrategy called twice for strategy " + name)
+	}
+	strategies.m[name] = f
+}
+
+// NewStrategy creates the strategy registered with `name`, configured
+// with `opts`.
+func NewStrategy(name string, opts StrategyOptions) (Strategy, error) {
+	strategies.Lock()
+	f, ok := strategies.m[name]
+	strategies.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("core: unknown strategy %q, available: %s", name, strings.Join(StrategyNames(), ", "))
+	}
+	return f(opts)
+}
+
+// StrategyNames returns the sorted list of registered strategy names.
+func StrategyNames() []string {
+	strategies.Lock()
+	defer strategies.Unlock()
+
+	acc := make([]string, 0, len(strategies.m))
+	for k := range strategies.m {
+		acc = append(acc, k)
+	}
+	sort.Strings(acc)
+	return acc
+}
@@ -0,0 +1,184 @@
+// Copyright © 2019 KIM KeepInMind GmbH/srl
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package core_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/booster-proj/booster/core"
+	"github.com/booster-proj/booster/testutil"
+)
+
+func first(ctx context.Context, candidates []core.SourceRecord, flow core.FlowDescriptor) (string, error) {
+	return candidates[0].ID, nil
+}
+
+// registrations makes the names registered by TestRegisterStrategy
+// unique, so that it can run more than once, e.g. with -count.
+var registrations int
+
+func TestRegisterStrategy(t *testing.T) {
+	registrations++
+	name := fmt.Sprintf("test_first_%d", registrations)
+	factory := func(opts core.StrategyOptions) (core.Strategy, error) {
+		if v, ok := opts["fail"]; ok {
+			return nil, fmt.Errorf("invalid fail option %q", v)
+		}
+		return core.StrategyFunc(first), nil
+	}
+	core.RegisterStrategy(name, factory)
+
+	if _, err := core.NewStrategy(name, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := core.NewStrategy(name, core.StrategyOptions{"fail": "yes"}); err == nil {
+		t.Fatalf("Expected error creating strategy with invalid options")
+	}
+	if _, err := core.NewStrategy("roundrobin", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := core.NewStrategy("roundrobin", core.StrategyOptions{"foo": "bar"}); err == nil {
+		t.Fatalf("Expected error creating roundrobin with options")
+	}
+	if _, err := core.NewStrategy("foo", nil); err == nil {
+		t.Fatalf("Expected error looking up unknown strategy")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("Expected panic registering strategy twice")
+			}
+		}()
+		core.RegisterStrategy(name, factory)
+	}()
+}
+
+func TestRoundRobin(t *testing.T) {
+	testutil.TestStrategy(t, core.RoundRobin)
+}
+
+func TestGet_strategyFallback(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	tt := []struct {
+		strategy core.StrategyFunc
+		cause    string
+	}{
+		{
+			strategy: func(ctx context.Context, candidates []core.SourceRecord, flow core.FlowDescriptor) (string, error) {
+				panic("broken strategy")
+			},
+			cause: core.FallbackPanic,
+		},
+		{
+			strategy: func(ctx context.Context, candidates []core.SourceRecord, flow core.FlowDescriptor) (string, error) {
+				return "", nil
+			},
+			cause: core.FallbackInvalid,
+		},
+		{
+			strategy: func(ctx context.Context, candidates []core.SourceRecord, flow core.FlowDescriptor) (string, error) {
+				return "baz", nil
+			},
+			cause: core.FallbackInvalid,
+		},
+		{
+			strategy: func(ctx context.Context, candidates []core.SourceRecord, flow core.FlowDescriptor) (string, error) {
+				<-block
+				return candidates[0].ID, nil
+			},
+			cause: core.FallbackTimeout,
+		},
+	}
+
+	for i, v := range tt {
+		events := make(chan core.StrategyEvent, 1)
+		b := &core.Balancer{Strategy: v.strategy, Events: events}
+		b.Put(newMock("foo"), newMock("bar"))
+
+		ctx := core.WithFlow(context.Background(), core.FlowDescriptor{Network: "tcp4", Target: "example.com:443"})
+		src, err := b.Get(ctx)
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %v", i, err)
+		}
+		if src == nil || src.ID() != "foo" {
+			t.Fatalf("%d: unexpected source: wanted foo, found %v", i, src)
+		}
+
+		select {
+		case e := <-events:
+			if e.Cause != v.cause || e.Flow.Target != "example.com:443" {
+				t.Fatalf("%d: unexpected event: %+v", i, e)
+			}
+		default:
+			t.Fatalf("%d: no strategy event emitted", i)
+		}
+
+		// The sources keep on being picked in turn.
+		if src, err = b.Get(ctx); err != nil || src.ID() != "bar" {
+			t.Fatalf("%d: unexpected source: wanted bar, found %v (%v)", i, src, err)
+		}
+	}
+}
+
+type reporter struct {
+	core.StrategyFunc
+	outcomes chan core.Outcome
+}
+
+func (r *reporter) Report(o core.Outcome) {
+	r.outcomes <- o
+}
+
+func TestBalancer_report(t *testing.T) {
+	s := &reporter{StrategyFunc: first, outcomes: make(chan core.Outcome, 1)}
+	b := &core.Balancer{Strategy: s}
+	b.Report(core.Outcome{SourceID: "foo"})
+	if o := <-s.outcomes; o.SourceID != "foo" {
+		t.Fatalf("Unexpected outcome: %+v", o)
+	}
+}
+
+func TestGet_flow(t *testing.T) {
+	var found core.FlowDescriptor
+	b := &core.Balancer{
+		Strategy: core.StrategyFunc(func(ctx context.Context, candidates []core.SourceRecord, flow core.FlowDescriptor) (string, error) {
+			found = flow
+			return candidates[len(candidates)-1].ID, nil
+		}),
+	}
+	b.Put(newMock("foo"), newMock("bar"), newMock("baz"))
+
+	flow := core.FlowDescriptor{Network: "tcp4", Target: "example.com:443", Attempt: 1}
+	src, err := b.Get(core.WithFlow(context.Background(), flow), newMock("baz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.ID() != "bar" {
+		t.Fatalf("Unexpected source: wanted bar, found %v", src.ID())
+	}
+	if found != flow {
+		t.Fatalf("Unexpected flow: wanted %+v, found %+v", flow, found)
+	}
+}
+
+func BenchmarkRoundRobin(b *testing.B) {
+	testutil.BenchmarkStrategy(b, core.RoundRobin)
+}
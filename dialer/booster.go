@@ -23,8 +23,12 @@ package dialer
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/booster-proj/booster/core"
 	"upspin.io/log"
@@ -48,41 +52,126 @@ type MetricsExporter interface {
 	IncSelectedSource(labels map[string]string)
 }
 
+// Notifier is implemented by balancers that are able to tell when the
+// sources they provide might have changed. The channel returned by
+// Changed is closed when that happens.
+type Notifier interface {
+	Changed() <-chan struct{}
+}
+
+// Reporter is implemented by balancers that want to know the outcome of
+// the connections dialed through the sources they provide, e.g. to let
+// their strategy learn from them.
+type Reporter interface {
+	Report(core.Outcome)
+}
+
+// ParkedExporter is implemented by metrics exporters that collect the
+// number of dials parked.
+type ParkedExporter interface {
+	SetParkedDials(n int)
+}
+
 // Dialer is a core.Dialer implementation, which uses a core.Balancer
 // instance to to retrieve a source to use when it comes to dial a network
 // connection.
 type Dialer struct {
 	b Balancer
 
+	// ParkTimeout is the maximum amount of time a dial waits for a
+	// source when none can be used, e.g. when every source is down for
+	// a moment. Dials are never parked for longer than their context
+	// allows. Zero disables parking, which also requires the Balancer
+	// to be a Notifier.
+	ParkTimeout time.Duration
+	// MaxParked is the maximum number of dials that can be parked at
+	// the same time. Dials that find no space fail immediately. Zero
+	// means no limit.
+	MaxParked int
+
+	parked struct {
+		sync.Mutex
+		n int
+	}
 	metrics struct {
 		sync.Mutex
 		exporter MetricsExporter
 	}
 }
 
+var errNoSource = errors.New("dialer: no source available")
+
 // DialContext dials a connection using `network` to `address`. The connection returned
 // is dialed through a specific network interface, which is chosen using the dialer's
 // interal balancer provided. If it fails to create a connection using a source, it
 // tries to dial it using another source, until source exhaustion. It that case,
 // only the last error received is returned.
-func (d *Dialer) DialContext(ctx context.Context, network, address string) (conn net.Conn, err error) {
+// If no source can be used at all and parking is enabled, the dial waits
+// for the sources to change, up to ParkTimeout.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var start time.Time
+	var timeout <-chan time.Time
+	for {
+		// Take the channel before looking for a source, so that
+		// changes happening in the meantime are not missed.
+		changed := d.changed()
+		conn, tried, err := d.dial(ctx, address)
+		if tried || changed == nil {
+			return conn, err
+		}
+
+		// There is no source that could be used for this dial.
+		if timeout == nil {
+			if !d.park() {
+				return nil, err
+			}
+			defer d.unpark()
+
+			start = time.Now()
+			t := time.NewTimer(d.ParkTimeout)
+			defer t.Stop()
+			timeout = t.C
+		}
+
+		select {
+		case <-changed:
+			log.Debug.Printf("DialContext: sources changed, retrying to connect to %v", address)
+		case <-timeout:
+			return nil, fmt.Errorf("%v, waited %v", err, time.Since(start))
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// dial tries to dial `address` with each source provided by the
+// balancer, until one succeeds. `tried` is false if not even one
+// source could be used.
+func (d *Dialer) dial(ctx context.Context, address string) (conn net.Conn, tried bool, err error) {
 	bl := make([]core.Source, 0, d.Len()) // blacklisted sources
 
 	// If the dialing fails, keep on trying with the other sources until exaustion.
 	for i := 0; len(bl) < d.Len(); i++ {
+		flow := core.FlowDescriptor{Network: "tcp4", Target: address, Attempt: i}
 		var src core.Source
-		src, err = d.b.Get(ctx, address, bl...)
+		src, err = d.b.Get(core.WithFlow(ctx, flow), address, bl...)
 		if err != nil {
 			// Fail directly if the balancer returns an error, as
 			// we do not have any source to use.
+			if !tried {
+				err = fmt.Errorf("%v: %v", errNoSource, err)
+			}
 			return
 		}
+		tried = true
 
 		d.sendMetrics(src.ID(), address)
 
 		log.Debug.Printf("DialContext: Attempt #%d to connect to %v (source %v)", i, address, src.ID())
 
-		conn, err = src.DialContext(ctx, "tcp4", address)
+		start := time.Now()
+		conn, err = src.DialContext(ctx, flow.Network, address)
+		d.report(core.Outcome{SourceID: src.ID(), Flow: flow, Err: err, DialTime: time.Since(start)})
 		if err != nil {
 			// Log this error, otherwise it will be silently skipped.
 			log.Error.Printf("Unable to dial connection to %v using source %v. Error: %v", address, src.ID(), err)
@@ -91,12 +180,120 @@ func (d *Dialer) DialContext(ctx context.Context, network, address string) (conn
 		}
 
 		// Connection dialed successfully.
+		if r, ok := d.b.(Reporter); ok {
+			conn = &reportConn{Conn: conn, r: r, id: src.ID(), flow: flow, start: time.Now()}
+		}
 		break
 	}
+	if !tried {
+		err = errNoSource
+	}
 
 	return
 }
 
+func (d *Dialer) report(o core.Outcome) {
+	if r, ok := d.b.(Reporter); ok {
+		r.Report(o)
+	}
+}
+
+// reportConn reports to its Reporter the bytes transferred once it is
+// closed. It keeps the half-close capabilities of the connection it wraps.
+type reportConn struct {
+	// Accessed atomically, first to be 64-bit aligned.
+	sent, received int64
+
+	net.Conn
+	r     Reporter
+	id    string
+	flow  core.FlowDescriptor
+	start time.Time
+	once  sync.Once
+}
+
+func (c *reportConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(&c.received, int64(n))
+	return n, err
+}
+
+func (c *reportConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(&c.sent, int64(n))
+	return n, err
+}
+
+func (c *reportConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		c.r.Report(core.Outcome{
+			SourceID: c.id,
+			Flow:     c.flow,
+			Closed:   true,
+			Sent:     atomic.LoadInt64(&c.sent),
+			Received: atomic.LoadInt64(&c.received),
+			Duration: time.Since(c.start),
+		})
+	})
+	return err
+}
+
+// CloseWrite shuts down the writing side of the underlying connection,
+// if it supports half-close, e.g. *source.Conn.
+func (c *reportConn) CloseWrite() error {
+	cw, ok := c.Conn.(interface{ CloseWrite() error })
+	if !ok {
+		return fmt.Errorf("dialer: %T does not support half-close", c.Conn)
+	}
+	return cw.CloseWrite()
+}
+
+// CloseRead shuts down the reading side of the underlying connection,
+// if it supports half-close.
+func (c *reportConn) CloseRead() error {
+	cr, ok := c.Conn.(interface{ CloseRead() error })
+	if !ok {
+		return fmt.Errorf("dialer: %T does not support half-close", c.Conn)
+	}
+	return cr.CloseRead()
+}
+
+// changed returns the channel signaling that the balancer's sources
+// changed, or nil if parking is disabled.
+func (d *Dialer) changed() <-chan struct{} {
+	n, ok := d.b.(Notifier)
+	if !ok || d.ParkTimeout <= 0 {
+		return nil
+	}
+	return n.Changed()
+}
+
+func (d *Dialer) park() bool {
+	d.parked.Lock()
+	defer d.parked.Unlock()
+	if d.MaxParked > 0 && d.parked.n >= d.MaxParked {
+		return false
+	}
+	d.parked.n++
+	d.sendParked(d.parked.n)
+	return true
+}
+
+func (d *Dialer) unpark() {
+	d.parked.Lock()
+	defer d.parked.Unlock()
+	d.parked.n--
+	d.sendParked(d.parked.n)
+}
+
+// Parked returns the number of dials currently parked.
+func (d *Dialer) Parked() int {
+	d.parked.Lock()
+	defer d.parked.Unlock()
+	return d.parked.n
+}
+
 // Len returns the number of sources that the dialer as at it's disposal.
 func (d *Dialer) Len() int {
 	return d.b.Len()
@@ -110,6 +307,15 @@ func (d *Dialer) SetMetricsExporter(exp MetricsExporter) {
 	d.metrics.exporter = exp
 }
 
+func (d *Dialer) sendParked(n int) {
+	d.metrics.Lock()
+	defer d.metrics.Unlock()
+
+	if exp, ok := d.metrics.exporter.(ParkedExporter); ok {
+		exp.SetParkedDials(n)
+	}
+}
+
 func (d *Dialer) sendMetrics(name, target string) {
 	if d.metrics.exporter == nil {
 		return
@@ -0,0 +1,284 @@
+// Copyright © 2019 KIM KeepInMind GmbH/srl
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package dialer_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/booster-proj/booster/core"
+	"github.com/booster-proj/booster/dialer"
+	"github.com/booster-proj/booster/source"
+	"github.com/booster-proj/booster/store"
+)
+
+type mock struct {
+	id string
+}
+
+func (s *mock) ID() string {
+	return s.id
+}
+
+func (s *mock) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	c, _ := net.Pipe()
+	return c, nil
+}
+
+func (s *mock) Close() error {
+	return nil
+}
+
+// balancer is a dialer.Balancer and a dialer.Notifier.
+type balancer struct {
+	sync.Mutex
+	sources []core.Source
+	c       chan struct{}
+}
+
+func (b *balancer) Get(ctx context.Context, target string, blacklisted ...core.Source) (core.Source, error) {
+	b.Lock()
+	defer b.Unlock()
+	if len(b.sources) == 0 {
+		return nil, errors.New("no sources")
+	}
+	return b.sources[0], nil
+}
+
+func (b *balancer) Len() int {
+	b.Lock()
+	defer b.Unlock()
+	return len(b.sources)
+}
+
+func (b *balancer) Changed() <-chan struct{} {
+	b.Lock()
+	defer b.Unlock()
+	if b.c == nil {
+		b.c = make(chan struct{})
+	}
+	return b.c
+}
+
+func (b *balancer) Put(src core.Source) {
+	b.Lock()
+	defer b.Unlock()
+	b.sources = append(b.sources, src)
+	if b.c != nil {
+		close(b.c)
+		b.c = nil
+	}
+}
+
+func waitParked(t *testing.T, d *dialer.Dialer, n int) {
+	deadline := time.Now().Add(time.Second)
+	for d.Parked() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Unexpected parked dials: wanted %d, found %d", n, d.Parked())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDialContext_noSource(t *testing.T) {
+	d := dialer.New(new(balancer))
+	conn, err := d.DialContext(context.Background(), "tcp", "host:80")
+	if err == nil {
+		t.Fatalf("Expected error, found connection %v", conn)
+	}
+}
+
+func TestDialContext_park(t *testing.T) {
+	b := new(balancer)
+	d := dialer.New(b)
+	d.ParkTimeout = time.Second
+
+	c := make(chan error)
+	go func() {
+		_, err := d.DialContext(context.Background(), "tcp", "host:80")
+		c <- err
+	}()
+
+	waitParked(t, d, 1)
+	b.Put(&mock{id: "foo"})
+
+	select {
+	case err := <-c:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Parked dial did not complete")
+	}
+	waitParked(t, d, 0)
+}
+
+func TestDialContext_parkTimeout(t *testing.T) {
+	d := dialer.New(new(balancer))
+	d.ParkTimeout = 10 * time.Millisecond
+
+	if _, err := d.DialContext(context.Background(), "tcp", "host:80"); err == nil {
+		t.Fatalf("Expected error after park timeout")
+	}
+	if n := d.Parked(); n != 0 {
+		t.Fatalf("Unexpected parked dials: wanted 0, found %d", n)
+	}
+}
+
+func TestDialContext_maxParked(t *testing.T) {
+	d := dialer.New(new(balancer))
+	d.ParkTimeout = time.Second
+	d.MaxParked = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan error)
+	go func() {
+		_, err := d.DialContext(ctx, "tcp", "host:80")
+		c <- err
+	}()
+	waitParked(t, d, 1)
+
+	// No more space, fail immediately.
+	start := time.Now()
+	if _, err := d.DialContext(context.Background(), "tcp", "host:80"); err == nil {
+		t.Fatalf("Expected error with no parking space left")
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Fatalf("Dial was parked")
+	}
+
+	cancel()
+	if err := <-c; err != context.Canceled {
+		t.Fatalf("Unexpected error: wanted %v, found %v", context.Canceled, err)
+	}
+}
+
+// reporter is a balancer that records the outcomes reported.
+type reporter struct {
+	balancer
+	outcomes []core.Outcome
+}
+
+func (r *reporter) Report(o core.Outcome) {
+	r.Lock()
+	defer r.Unlock()
+	r.outcomes = append(r.outcomes, o)
+}
+
+func TestDialContext_report(t *testing.T) {
+	b := &reporter{}
+	b.Put(&mock{id: "foo"})
+	d := dialer.New(b)
+
+	conn, err := d.DialContext(context.Background(), "tcp", "example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	conn.Close()
+
+	b.Lock()
+	defer b.Unlock()
+	if len(b.outcomes) != 2 {
+		t.Fatalf("Unexpected outcomes: wanted 2, found %+v", b.outcomes)
+	}
+	dial, closed := b.outcomes[0], b.outcomes[1]
+	if dial.SourceID != "foo" || dial.Err != nil || dial.Closed || dial.Flow.Target != "example.com:443" {
+		t.Fatalf("Unexpected dial outcome: %+v", dial)
+	}
+	if closed.SourceID != "foo" || !closed.Closed {
+		t.Fatalf("Unexpected close outcome: %+v", closed)
+	}
+}
+
+// tcp is a source dialing real TCP connections, wrapped like the
+// ones of the actual sources.
+type tcp struct {
+	mock
+}
+
+func (s *tcp) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &source.Conn{Conn: c}, nil
+}
+
+func TestDialContext_closeWrite(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The server reads everything until EOF, then replies.
+	errc := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer conn.Close()
+		b, err := ioutil.ReadAll(conn)
+		if err != nil {
+			errc <- err
+			return
+		}
+		_, err = conn.Write(append([]byte("got "), b...))
+		errc <- err
+	}()
+
+	s := store.New(new(core.Balancer))
+	s.Put(&tcp{mock{id: "foo"}})
+	d := dialer.New(s)
+
+	conn, err := d.DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	cw, ok := conn.(interface{ CloseWrite() error })
+	if !ok {
+		t.Fatalf("Connection %T does not support half-close", conn)
+	}
+	if err := cw.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reading must still be possible after the half-close.
+	b, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "got ping" {
+		t.Fatalf("Unexpected reply: wanted \"got ping\", found %q", b)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright © 2019 KIM KeepInMind GmbH/srl
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+//go:build go1.18
+// +build go1.18
+
+package match_test
+
+import (
+	"testing"
+
+	"github.com/booster-proj/booster/match"
+)
+
+// FuzzCompile checks that the parser never panics, and that compiled
+// matchers round-trip through their pattern.
+func FuzzCompile(f *testing.F) {
+	for _, v := range []string{
+		"",
+		"*",
+		"example.com",
+		"example.com:443",
+		".example.com",
+		"*.example.com:8000-9000",
+		"api-*.example.*",
+		"10.0.0.0/8",
+		"fe80::/10",
+		"[::1]:53",
+		"fe80::1%en0",
+		"bücher.de",
+		"bu\u0308cher.de:443",
+		"*.bücher.de",
+		"bü*.de",
+		"example.com;source=en0,class!=bulk",
+		"[::1",
+		"example.com:90-80",
+	} {
+		f.Add(v)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		m, err := match.Compile(s)
+		if err != nil {
+			return
+		}
+		// The pattern of a matcher must compile to a matcher that
+		// behaves the same way.
+		r, err := match.Compile(m.String())
+		if err != nil {
+			t.Fatalf("unable to compile %q, from %q: %v", m.String(), s, err)
+		}
+		if r.String() != m.String() {
+			t.Fatalf("unexpected pattern: wanted %q, found %q", m.String(), r.String())
+		}
+		f := match.Flow{Target: s, Labels: map[string]string{"source": s}}
+		if r.Match(s) != m.Match(s) || r.MatchFlow(f) != m.MatchFlow(f) {
+			t.Fatalf("%q does not round-trip on target %q", s, s)
+		}
+		match.IsPattern(s)
+	})
+}
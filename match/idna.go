@@ -0,0 +1,66 @@
+// Copyright © 2019 KIM KeepInMind GmbH/srl
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package match
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// toASCII converts the labels of `host` that contain non ASCII
+// characters to their "xn--" form, following the IDNA lookup rules
+// (which include the NFC normalization), so that internationalized
+// domain names compare equal to their ASCII encoding. Labels that
+// cannot be converted, or that contain a glob wildcard, are left
+// untouched and reported in the error.
+func toASCII(host string) (string, error) {
+	if isASCII(host) {
+		return host, nil
+	}
+	var err error
+	labels := strings.Split(host, ".")
+	for i, v := range labels {
+		if isASCII(v) {
+			continue
+		}
+		if strings.Contains(v, "*") {
+			if err == nil {
+				err = fmt.Errorf("wildcard in internationalized label %q", v)
+			}
+			continue
+		}
+		s, lerr := idna.Lookup.ToASCII(v)
+		if lerr != nil {
+			if err == nil {
+				err = fmt.Errorf("invalid label %q: %v", v, lerr)
+			}
+			continue
+		}
+		labels[i] = s
+	}
+	return strings.Join(labels, "."), err
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
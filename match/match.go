@@ -0,0 +1,409 @@
+// Copyright © 2019 KIM KeepInMind GmbH/srl
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package match provides the target matching rules shared by the
+// components that have to decide wether a connection target, i.e.
+// an "host:port" address, is covered by a user defined pattern.
+//
+// A pattern is made of an host part and an optional port part,
+// separated by a colon. The host part may be:
+//   - an hostname or IP address, matched exactly ("example.com", "::1")
+//   - a domain preceded by a dot, matching the domain itself and all its
+//     subdomains (".example.com")
+//   - a glob, where "*" matches any sequence of characters, dots
+//     included ("*.example.com", "api-*.example.com")
+//   - a CIDR block, matching IP targets only ("10.0.0.0/8", "fe80::/10")
+//   - "*", matching any host.
+//
+// The port part may be a single port ("443"), an inclusive range
+// ("8000-9000") or "*". IPv6 addresses have to be enclosed in brackets
+// when a port is specified ("[::1]:53").
+// Hosts are compared case insensitively, ignoring trailing dots, brackets
+// and IPv6 zones. Internationalized domain names are compared in their
+// ASCII form, hence "bücher.de" and "xn--bcher-kva.de" are equivalent;
+// wildcards are not allowed inside internationalized labels.
+//
+// A pattern may end with a list of label selectors, separated from the
+// address by a semicolon and from each other by commas
+// ("*.example.com:443;source=en0,class!=bulk"). Selectors are checked
+// against the labels of the Flow being matched: "key=value" requires the
+// label to be present and equal to value, "key!=value" requires it to
+// be either absent or different.
+package match
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+type kind int
+
+const (
+	kindAny kind = iota
+	kindExact
+	kindSuffix
+	kindGlob
+	kindCIDR
+)
+
+// Matcher is the compiled form of a pattern. Create one with Compile.
+type Matcher struct {
+	pattern string
+	kind    kind
+
+	host  string     // normalized host, used by exact, suffix and glob matchers.
+	ip    net.IP     // set when an exact matcher refers to an IP address.
+	ipnet *net.IPNet // used by CIDR matchers.
+
+	// ports, when hasPorts is true, is the inclusive range of ports
+	// accepted.
+	hasPorts bool
+	ports    [2]int
+
+	selectors []selector
+}
+
+// Flow describes a connection that is matched against patterns: its
+// target, either an host or an "host:port" address, and the labels
+// attached to it, e.g. the identifier of the source that would be used.
+type Flow struct {
+	Target string
+	Labels map[string]string
+}
+
+type selector struct {
+	key, value string
+	negated    bool
+}
+
+func (s selector) match(labels map[string]string) bool {
+	v, ok := labels[s.key]
+	if s.negated {
+		return !ok || v != s.value
+	}
+	return ok && v == s.value
+}
+
+// Compile parses `pattern` and returns a Matcher for it.
+func Compile(pattern string) (*Matcher, error) {
+	address, labels := splitLabels(pattern)
+	host, port, err := splitPattern(address)
+	if err != nil {
+		return nil, fmt.Errorf("match: invalid pattern %q: %v", pattern, err)
+	}
+
+	m := &Matcher{pattern: pattern}
+	if m.selectors, err = parseSelectors(labels); err != nil {
+		return nil, fmt.Errorf("match: invalid pattern %q: %v", pattern, err)
+	}
+	if port != "" && port != "*" {
+		m.hasPorts = true
+		if m.ports, err = parsePorts(port); err != nil {
+			return nil, fmt.Errorf("match: invalid pattern %q: %v", pattern, err)
+		}
+	}
+
+	raw := host
+	if host, err = normalize(host); err != nil {
+		return nil, fmt.Errorf("match: invalid pattern %q: %v", pattern, err)
+	}
+	switch {
+	case host == "" && raw != "":
+		return nil, fmt.Errorf("match: invalid pattern %q: empty host", pattern)
+	case host == "" || host == "*":
+		m.kind = kindAny
+	case strings.Contains(host, "/"):
+		_, ipnet, err := net.ParseCIDR(host)
+		if err != nil {
+			return nil, fmt.Errorf("match: invalid pattern %q: %v", pattern, err)
+		}
+		m.kind = kindCIDR
+		m.ipnet = ipnet
+	case strings.HasPrefix(host, "."):
+		m.kind = kindSuffix
+		m.host = strings.TrimLeft(host, ".")
+		if m.host == "" {
+			return nil, fmt.Errorf("match: invalid pattern %q: empty domain", pattern)
+		}
+	case strings.Contains(host, "*"):
+		m.kind = kindGlob
+		m.host = host
+	default:
+		m.kind = kindExact
+		m.host = host
+		m.ip = net.ParseIP(host)
+	}
+
+	return m, nil
+}
+
+// MustCompile is like Compile but panics if `pattern` is not valid.
+func MustCompile(pattern string) *Matcher {
+	m, err := Compile(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// String returns the pattern `m` was compiled from.
+func (m *Matcher) String() string {
+	return m.pattern
+}
+
+// Match reports wether `target`, either an host or an "host:port"
+// address, matches the pattern. Targets without port information
+// never match patterns that restrict the port. It is equivalent to
+// calling MatchFlow with a Flow without labels.
+func (m *Matcher) Match(target string) bool {
+	return m.MatchFlow(Flow{Target: target})
+}
+
+// MatchFlow reports wether `f` matches the pattern, i.e. its target
+// matches the address part and its labels satisfy all selectors.
+func (m *Matcher) MatchFlow(f Flow) bool {
+	for _, v := range m.selectors {
+		if !v.match(f.Labels) {
+			return false
+		}
+	}
+
+	host, port := splitTarget(f.Target)
+	if m.hasPorts {
+		p, err := strconv.Atoi(port)
+		if err != nil || p < m.ports[0] || p > m.ports[1] {
+			return false
+		}
+	}
+
+	switch m.kind {
+	case kindAny:
+		return true
+	case kindCIDR:
+		ip := parseIP(host)
+		return ip != nil && m.ipnet.Contains(ip)
+	}
+
+	host = Normalize(host)
+	switch m.kind {
+	case kindExact:
+		if m.ip != nil {
+			ip := parseIP(host)
+			return ip != nil && ip.Equal(m.ip)
+		}
+		return host == m.host
+	case kindSuffix:
+		return host == m.host || strings.HasSuffix(host, "."+m.host)
+	case kindGlob:
+		return glob(m.host, host)
+	}
+	return false
+}
+
+// Any reports wether `target` matches at least one of `matchers`.
+func Any(matchers []*Matcher, target string) bool {
+	return AnyFlow(matchers, Flow{Target: target})
+}
+
+// AnyFlow reports wether `f` matches at least one of `matchers`.
+func AnyFlow(matchers []*Matcher, f Flow) bool {
+	for _, m := range matchers {
+		if m.MatchFlow(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPattern reports wether `s` uses any pattern syntax, i.e. it is not
+// just an hostname or an IP address, optionally followed by a port.
+func IsPattern(s string) bool {
+	if strings.Contains(s, ";") {
+		return true
+	}
+	host, _, err := splitPattern(s)
+	if err != nil {
+		return false
+	}
+	return host == "" || strings.ContainsAny(host, "*/") || strings.HasPrefix(host, ".")
+}
+
+// Normalize returns the canonical form of `host`, the one used for
+// comparisons: lower case, without brackets, trailing dots and IPv6
+// zone, with internationalized labels converted to their ASCII form.
+// Labels that are not valid internationalized names are left as they are.
+func Normalize(host string) string {
+	host, _ = normalize(host)
+	return host
+}
+
+// normalize is like Normalize, but it also reports the labels of
+// `host` that could not be converted to ASCII.
+func normalize(host string) (string, error) {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if i := strings.LastIndex(host, "%"); i >= 0 && strings.Contains(host, ":") {
+		host = host[:i]
+	}
+	host = strings.TrimRight(host, ".")
+	return toASCII(strings.ToLower(host))
+}
+
+// Canonical returns `pattern` with its host part normalized, see
+// Normalize, and the port and the label selectors left untouched. It
+// allows to tell when two patterns, as typed by users, are the same.
+// Invalid patterns are returned as they are.
+func Canonical(pattern string) string {
+	address, labels := splitLabels(pattern)
+	host, port, err := splitPattern(address)
+	if err != nil {
+		return pattern
+	}
+	acc := Normalize(host)
+	// Brackets are needed when the colons of the host could be mistaken
+	// for the port separator.
+	if strings.Contains(acc, ":") && (port != "" || strings.Count(acc, ":") == 1) {
+		acc = "[" + acc + "]"
+	}
+	if port != "" {
+		acc += ":" + port
+	}
+	if labels != "" {
+		acc += ";" + labels
+	}
+	return acc
+}
+
+// splitLabels separates the address part of a pattern from its label
+// selectors.
+func splitLabels(pattern string) (address, labels string) {
+	if i := strings.Index(pattern, ";"); i >= 0 {
+		return pattern[:i], pattern[i+1:]
+	}
+	return pattern, ""
+}
+
+func parseSelectors(s string) ([]selector, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var acc []selector
+	for _, v := range strings.Split(s, ",") {
+		var sel selector
+		i := strings.Index(v, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid label selector %q", v)
+		}
+		sel.key, sel.value = v[:i], v[i+1:]
+		if strings.HasSuffix(sel.key, "!") {
+			sel.key, sel.negated = sel.key[:len(sel.key)-1], true
+		}
+		if sel.key == "" {
+			return nil, fmt.Errorf("invalid label selector %q: empty key", v)
+		}
+		acc = append(acc, sel)
+	}
+	return acc, nil
+}
+
+// splitPattern separates the host and the port part of a pattern.
+func splitPattern(pattern string) (host, port string, err error) {
+	if strings.HasPrefix(pattern, "[") {
+		i := strings.Index(pattern, "]")
+		if i < 0 {
+			return "", "", fmt.Errorf("missing ']'")
+		}
+		host, rest := pattern[1:i], pattern[i+1:]
+		if rest == "" {
+			return host, "", nil
+		}
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", fmt.Errorf("unexpected %q after ']'", rest)
+		}
+		return host, rest[1:], nil
+	}
+	if strings.Count(pattern, ":") == 1 {
+		i := strings.Index(pattern, ":")
+		return pattern[:i], pattern[i+1:], nil
+	}
+	// No port, or an IPv6 address (or block) without brackets.
+	return pattern, "", nil
+}
+
+// splitTarget separates the host and the port of a target address,
+// if present.
+func splitTarget(target string) (host, port string) {
+	if h, p, err := net.SplitHostPort(target); err == nil {
+		return h, p
+	}
+	return target, ""
+}
+
+func parsePorts(s string) ([2]int, error) {
+	var r [2]int
+	lo, hi := s, s
+	if i := strings.Index(s, "-"); i >= 0 {
+		lo, hi = s[:i], s[i+1:]
+	}
+
+	var err error
+	if r[0], err = parsePort(lo); err != nil {
+		return r, err
+	}
+	if r[1], err = parsePort(hi); err != nil {
+		return r, err
+	}
+	if r[0] > r[1] {
+		return r, fmt.Errorf("invalid port range %s", s)
+	}
+	return r, nil
+}
+
+func parsePort(s string) (int, error) {
+	p, err := strconv.Atoi(s)
+	if err != nil || p < 0 || p > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return p, nil
+}
+
+func parseIP(host string) net.IP {
+	return net.ParseIP(Normalize(host))
+}
+
+// glob reports wether `s` matches `pattern`, where "*" matches any
+// sequence of characters.
+func glob(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+
+	// The first and last parts are anchored.
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, v := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, v)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(v):]
+	}
+	return strings.HasSuffix(s, last)
+}
@@ -0,0 +1,263 @@
+// Copyright © 2019 KIM KeepInMind GmbH/srl
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package match_test
+
+import (
+	"testing"
+
+	"github.com/booster-proj/booster/match"
+)
+
+func TestMatch(t *testing.T) {
+	tt := []struct {
+		pattern string
+		target  string
+		ok      bool
+	}{
+		// exact
+		{"example.com", "example.com", true},
+		{"example.com", "EXAMPLE.com.", true},
+		{"example.com", "example.com:443", true},
+		{"example.com", "api.example.com", false},
+		{"10.0.0.1", "10.0.0.1:80", true},
+		{"::1", "[::1]:53", true},
+		{"[::1]", "0:0::1", true},
+		{"fe80::1", "fe80::1%en0", true},
+		{"fe80::1", "fe80::2", false},
+
+		// suffix
+		{".example.com", "example.com", true},
+		{".example.com", "a.b.example.com", true},
+		{".example.com", "badexample.com", false},
+
+		// glob
+		{"*.example.com", "api.example.com", true},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"api-*.example.com", "api-eu.example.com", true},
+		{"api-*.example.com", "web-eu.example.com", false},
+		{"*", "anything", true},
+
+		// cidr
+		{"10.0.0.0/8", "10.255.0.1", true},
+		{"10.0.0.0/8", "11.0.0.1", false},
+		{"10.0.0.0/8", "example.com", false},
+		{"fe80::/10", "[fe80::1]:443", true},
+
+		// ports
+		{"example.com:443", "example.com:443", true},
+		{"example.com:443", "example.com:80", false},
+		{"example.com:443", "example.com", false},
+		{"*:8000-9000", "host:8080", true},
+		{"*:8000-9000", "host:9001", false},
+		{"[::1]:53", "[::1]:53", true},
+		{"example.com:*", "example.com", true},
+		{"*:443", "example.com:80", false},
+		{"*:443", "example.com:443", true},
+
+		// idn
+		{"bücher.de", "xn--bcher-kva.de", true},
+		{"xn--bcher-kva.de", "BÜCHER.de:443", true},
+		{".münchen.de", "www.xn--mnchen-3ya.de", true},
+		{"*.bücher.de", "shop.bücher.de", true},
+		{"bücher.de", "bucher.de", false},
+		{"*.bücher.de", "shop.xn--bcher-kva.de:443", true},
+		{"b*.de", "bücher.de", false},
+		{"xn--*.de", "bücher.de", true},
+
+		// idn, non NFC input: "u" followed by a combining diaeresis.
+		{"bu\u0308cher.de", "bücher.de", true},
+		{"bücher.de", "bu\u0308cher.de:443", true},
+		{"bu\u0308cher.de", "xn--bcher-kva.de", true},
+	}
+
+	for i, v := range tt {
+		m, err := match.Compile(v.pattern)
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %v", i, err)
+		}
+		if ok := m.Match(v.target); ok != v.ok {
+			t.Fatalf("%d: pattern %s, target %s: wanted %v, found %v", i, v.pattern, v.target, v.ok, ok)
+		}
+	}
+}
+
+func TestCompile_invalid(t *testing.T) {
+	tt := []string{
+		"10.0.0.0/33",
+		"example.com:http",
+		"example.com:90-80",
+		"example.com:70000",
+		"[::1",
+		"[::1]53",
+		".",
+		"example.com;source",
+		"example.com;=en0",
+		"example.com;!=en0",
+		"bü*.de",
+		"*.mü*chen.de:443",
+	}
+
+	for i, v := range tt {
+		if _, err := match.Compile(v); err == nil {
+			t.Fatalf("%d: expected error compiling %q", i, v)
+		}
+	}
+}
+
+func TestIsPattern(t *testing.T) {
+	tt := []struct {
+		in string
+		ok bool
+	}{
+		{"example.com", false},
+		{"10.0.0.1", false},
+		{"::1", false},
+		{"*.example.com", true},
+		{".example.com", true},
+		{"10.0.0.0/8", true},
+		{"fe80::/10", true},
+		{"example.com:443", false},
+		{"example.com;source=en0", true},
+	}
+
+	for i, v := range tt {
+		if ok := match.IsPattern(v.in); ok != v.ok {
+			t.Fatalf("%d: IsPattern(%q): wanted %v, found %v", i, v.in, v.ok, ok)
+		}
+	}
+}
+
+func TestMatchFlow(t *testing.T) {
+	tt := []struct {
+		pattern string
+		labels  map[string]string
+		ok      bool
+	}{
+		{"example.com;source=en0", map[string]string{"source": "en0"}, true},
+		{"example.com;source=en0", map[string]string{"source": "en1"}, false},
+		{"example.com;source=en0", nil, false},
+		{"example.com;source!=en0", map[string]string{"source": "en1"}, true},
+		{"example.com;source!=en0", map[string]string{"source": "en0"}, false},
+		{"example.com;source!=en0", nil, true},
+		{"example.com;source=en0,class=bulk", map[string]string{"source": "en0", "class": "bulk"}, true},
+		{"example.com;source=en0,class=bulk", map[string]string{"source": "en0"}, false},
+		{"other.com;source=en0", map[string]string{"source": "en0"}, false},
+	}
+
+	for i, v := range tt {
+		m, err := match.Compile(v.pattern)
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %v", i, err)
+		}
+		f := match.Flow{Target: "example.com:443", Labels: v.labels}
+		if ok := m.MatchFlow(f); ok != v.ok {
+			t.Fatalf("%d: pattern %s, labels %v: wanted %v, found %v", i, v.pattern, v.labels, v.ok, ok)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tt := []struct {
+		in  string
+		out string
+	}{
+		{"Example.COM.", "example.com"},
+		{"[fe80::1%en0]", "fe80::1"},
+		{"bücher.de", "xn--bcher-kva.de"},
+		{"MÜNCHEN.de", "xn--mnchen-3ya.de"},
+		{"bu\u0308cher.de", "xn--bcher-kva.de"},
+		{"bü*.de", "bü*.de"},
+		{"xn--bcher-kva.de", "xn--bcher-kva.de"},
+	}
+
+	for i, v := range tt {
+		if out := match.Normalize(v.in); out != v.out {
+			t.Fatalf("%d: Normalize(%q): wanted %q, found %q", i, v.in, v.out, out)
+		}
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	tt := []struct {
+		in  string
+		out string
+	}{
+		{"Example.COM.", "example.com"},
+		{"Example.COM:443", "example.com:443"},
+		{"[FE80::1%en0]:53", "[fe80::1]:53"},
+		{"FE80::1", "fe80::1"},
+		{"*.Bücher.de:*;source=en0", "*.xn--bcher-kva.de:*;source=en0"},
+		{"[::1", "[::1"},
+		{"A:%:", "[a:]"},
+	}
+
+	for i, v := range tt {
+		if out := match.Canonical(v.in); out != v.out {
+			t.Fatalf("%d: Canonical(%q): wanted %q, found %q", i, v.in, v.out, out)
+		}
+	}
+}
+
+func TestSpecificity(t *testing.T) {
+	// Each pattern is more specific than the following one.
+	tt := []string{
+		"api.example.com:443;source=en0",
+		"api.example.com:443",
+		"api.example.com:8000-9000",
+		"api.example.com",
+		"api-*.example.com",
+		"*.example.com",
+		".example.com",
+		"10.8.3.0/24",
+		"10.8.0.0/16",
+		"*:443",
+		"*",
+	}
+
+	for i := 0; i < len(tt)-1; i++ {
+		a, b := match.MustCompile(tt[i]).Specificity(), match.MustCompile(tt[i+1]).Specificity()
+		if c := a.Compare(b); c != 1 {
+			t.Fatalf("%d: comparing %q with %q: wanted 1, found %d", i, tt[i], tt[i+1], c)
+		}
+		if c := b.Compare(a); c != -1 {
+			t.Fatalf("%d: comparing %q with %q: wanted -1, found %d", i, tt[i+1], tt[i], c)
+		}
+	}
+
+	var zero match.Specificity
+	if c := zero.Compare(match.MustCompile("*").Specificity()); c != -1 {
+		t.Fatalf("Unexpected zero specificity comparison: wanted -1, found %d", c)
+	}
+	if c := match.MustCompile("example.com").Specificity().Compare(match.MustCompile("10.0.0.1").Specificity()); c != 0 {
+		t.Fatalf("Unexpected exact specificity comparison: wanted 0, found %d", c)
+	}
+}
+
+func BenchmarkMatch(b *testing.B) {
+	ms := []*match.Matcher{
+		match.MustCompile("example.com"),
+		match.MustCompile(".example.org"),
+		match.MustCompile("*.example.net"),
+		match.MustCompile("10.0.0.0/8"),
+		match.MustCompile("*:8000-9000"),
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		match.Any(ms, "api.example.net:443")
+	}
+}
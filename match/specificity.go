@@ -0,0 +1,88 @@
+// Copyright © 2019 KIM KeepInMind GmbH/srl
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package match
+
+import "strings"
+
+// Specificity tells how narrow the set of targets selected by a pattern
+// is, so that overlapping patterns can be ordered. The zero value is less
+// specific than any pattern.
+type Specificity struct {
+	class  int // 1 any, 2 CIDR, 3 suffix and glob, 4 exact
+	length int // CIDR prefix length, or literal characters of the host
+	ports  int // number of ports accepted, 0 if not restricted
+	labels int // number of label selectors
+}
+
+// Specificity returns the specificity of `m`: exact hosts beat domains
+// and globs, which beat CIDR blocks, which beat "*". CIDR blocks with
+// a longer prefix and domains or globs with more literal characters win
+// within their class, then patterns accepting fewer ports, then patterns
+// with more label selectors.
+func (m *Matcher) Specificity() Specificity {
+	var s Specificity
+	switch m.kind {
+	case kindAny:
+		s.class = 1
+	case kindCIDR:
+		s.class = 2
+		s.length, _ = m.ipnet.Mask.Size()
+	case kindSuffix:
+		// ".example.com" also matches "example.com", hence it is
+		// less specific than "*.example.com".
+		s.class, s.length = 3, len(m.host)
+	case kindGlob:
+		s.class, s.length = 3, len(m.host)-strings.Count(m.host, "*")
+	case kindExact:
+		s.class = 4
+	}
+	if m.hasPorts {
+		s.ports = m.ports[1] - m.ports[0] + 1
+	}
+	s.labels = len(m.selectors)
+	return s
+}
+
+// Compare returns -1, 0 or +1 depending on wether `s` is less, equally
+// or more specific than `o`.
+func (s Specificity) Compare(o Specificity) int {
+	if c := cmp(s.class, o.class); c != 0 {
+		return c
+	}
+	if c := cmp(s.length, o.length); c != 0 {
+		return c
+	}
+	if s.ports != o.ports {
+		switch {
+		case s.ports == 0:
+			return -1
+		case o.ports == 0:
+			return 1
+		}
+		return -cmp(s.ports, o.ports)
+	}
+	return cmp(s.labels, o.labels)
+}
+
+func cmp(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -64,15 +64,52 @@ var (
 		Name:      "port_count",
 		Help:      "Number of times a port is being used",
 	}, []string{"port", "protocol"})
+
+	parkedDials = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "parked_dials",
+		Help:      "Number of dials waiting for a source to become available",
+	})
+
+	pollInterval = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "poll_interval_seconds",
+		Help:      "Time the listener waits before polling the sources again",
+	})
+
+	checkLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "check_latency_ms",
+		Help:      "Time taken by the last successful connection check of the source, in milliseconds",
+	}, []string{"source"})
+
+	strategyFallback = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "strategy_fallback_total",
+		Help:      "Number of times the strategy misbehaved and round robin was used in its place",
+	}, []string{"cause"})
 )
 
 func init() {
-	prometheus.MustRegister(sendBytes)
-	prometheus.MustRegister(receiveBytes)
-	prometheus.MustRegister(selectSource)
-	prometheus.MustRegister(countConn)
-	prometheus.MustRegister(addLatency)
-	prometheus.MustRegister(countPort)
+	prometheus.MustRegister(Collectors()...)
+}
+
+// Collectors returns the collectors of the metrics updated by Exporter.
+// They are registered with the default prometheus registry, which is
+// used both to serve them and to write them to a textfile.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		sendBytes,
+		receiveBytes,
+		selectSource,
+		countConn,
+		addLatency,
+		countPort,
+		parkedDials,
+		pollInterval,
+		checkLatency,
+		strategyFallback,
+	}
 }
 
 // Exporter can be used to both capture and serve metrics.
@@ -121,3 +158,26 @@ func (exp *Exporter) AddLatency(labels map[string]string, d time.Duration) {
 func (exp *Exporter) CountPort(labels map[string]string, val int) {
 	countPort.With(prometheus.Labels(labels)).Add(float64(val))
 }
+
+// SetParkedDials updates the number of dials waiting for a source.
+func (exp *Exporter) SetParkedDials(n int) {
+	parkedDials.Set(float64(n))
+}
+
+// SetPollInterval updates the interval between the polls of the
+// listener.
+func (exp *Exporter) SetPollInterval(d time.Duration) {
+	pollInterval.Set(d.Seconds())
+}
+
+// SetCheckLatency updates the time taken by the last successful
+// check of a source.
+func (exp *Exporter) SetCheckLatency(labels map[string]string, d time.Duration) {
+	checkLatency.With(prometheus.Labels(labels)).Set(d.Seconds() * 1000)
+}
+
+// IncStrategyFallback is used to update the number of times the strategy
+// was replaced by round robin.
+func (exp *Exporter) IncStrategyFallback(labels map[string]string) {
+	strategyFallback.With(prometheus.Labels(labels)).Inc()
+}
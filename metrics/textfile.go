@@ -0,0 +1,89 @@
+// Copyright © 2019 KIM KeepInMind GmbH/srl
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/booster-proj/booster/core"
+	"github.com/prometheus/client_golang/prometheus"
+	"upspin.io/log"
+)
+
+// TextfileWriter periodically writes the metrics exported by booster to
+// a file, in the format expected by the node_exporter textfile
+// collector. The metrics are the same ones served by Exporter.
+type TextfileWriter struct {
+	// Path of the file, which should have the ".prom" extension.
+	Path string
+	// Interval between each write.
+	Interval time.Duration
+	// Gatherer provides the metrics to write. Defaults to
+	// prometheus.DefaultGatherer.
+	Gatherer prometheus.Gatherer
+	// Clock is used to schedule the writes. Defaults to
+	// core.SystemClock.
+	Clock core.Clock
+}
+
+// Run writes the metrics to Path every Interval, until `ctx` is canceled.
+// Each write replaces the file atomically, so the collector never reads
+// a partial file. When a write takes longer than Interval, the writes
+// that were due in the meantime are skipped. The file is removed when
+// Run returns.
+func (w *TextfileWriter) Run(ctx context.Context) error {
+	if w.Interval <= 0 {
+		return fmt.Errorf("metrics: invalid textfile interval %v", w.Interval)
+	}
+	g := w.Gatherer
+	if g == nil {
+		g = prometheus.DefaultGatherer
+	}
+	clock := w.Clock
+	if clock == nil {
+		clock = core.SystemClock
+	}
+	defer func() {
+		if err := os.Remove(w.Path); err != nil && !os.IsNotExist(err) {
+			log.Error.Printf("metrics: unable to remove textfile: %v", err)
+		}
+	}()
+
+	ticker := clock.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		start := clock.Now()
+		if err := prometheus.WriteToTextfile(w.Path, g); err != nil {
+			log.Error.Printf("metrics: unable to write textfile: %v", err)
+		}
+		if d := clock.Now().Sub(start); d > w.Interval {
+			log.Error.Printf("metrics: writing %s took %v, longer than the %v interval: skipping next write", w.Path, d, w.Interval)
+			select {
+			case <-ticker.C():
+			default:
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C():
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright © 2019 KIM KeepInMind GmbH/srl
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package metrics_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/booster-proj/booster/metrics"
+	"github.com/booster-proj/booster/source"
+	"github.com/booster-proj/booster/testutil"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// newRegistry returns a registry with the collectors of the exporter,
+// after updating all of them through the exporter itself.
+func newRegistry() *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	r.MustRegister(metrics.Collectors()...)
+
+	exp := new(metrics.Exporter)
+	labels := map[string]string{"source": "en0", "target": "example.com"}
+	exp.SendDataFlow(labels, &source.DataFlow{Type: "write", N: 1024})
+	exp.SendDataFlow(labels, &source.DataFlow{Type: "read", N: 2048})
+	exp.IncSelectedSource(labels)
+	exp.IncSelectedSource(map[string]string{"source": "en4", "target": "example.org"})
+	exp.CountOpenConn(labels, 3)
+	exp.AddLatency(labels, 25*time.Millisecond)
+	exp.CountPort(map[string]string{"port": "443", "protocol": "tcp"}, 1)
+	exp.SetParkedDials(2)
+	exp.SetPollInterval(5 * time.Second)
+	exp.SetCheckLatency(map[string]string{"source": "en0"}, 1500*time.Microsecond)
+	exp.IncStrategyFallback(map[string]string{"cause": "timeout"})
+	return r
+}
+
+func value(m *dto.Metric) float64 {
+	switch {
+	case m.GetGauge() != nil:
+		return m.GetGauge().GetValue()
+	case m.GetCounter() != nil:
+		return m.GetCounter().GetValue()
+	default:
+		return m.GetUntyped().GetValue()
+	}
+}
+
+func waitFile(t *testing.T, path string) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		if b, err := ioutil.ReadFile(path); err == nil && len(b) > 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("textfile %s was not written", path)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTextfileWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "booster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := newRegistry()
+	w := &metrics.TextfileWriter{
+		Path:     filepath.Join(dir, "booster.prom"),
+		Interval: time.Hour,
+		Gatherer: r,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan error)
+	go func() {
+		c <- w.Run(ctx)
+	}()
+	waitFile(t, w.Path)
+
+	f, err := os.Open(w.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p expfmt.TextParser
+	found, err := p.TextToMetricFamilies(f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("Unable to parse textfile: %v", err)
+	}
+	wanted, err := r.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(metrics.Collectors()); len(wanted) != n {
+		t.Fatalf("Unexpected number of metric families gathered: wanted %d, found %d", n, len(wanted))
+	}
+	if len(found) != len(wanted) {
+		t.Fatalf("Unexpected number of metric families: wanted %d, found %d", len(wanted), len(found))
+	}
+	for _, wf := range wanted {
+		ff, ok := found[wf.GetName()]
+		if !ok {
+			t.Fatalf("Metric family %s not found", wf.GetName())
+		}
+		if ff.GetType() != wf.GetType() || ff.GetHelp() != wf.GetHelp() {
+			t.Fatalf("%s: unexpected type or help: wanted %v %q, found %v %q", wf.GetName(), wf.GetType(), wf.GetHelp(), ff.GetType(), ff.GetHelp())
+		}
+		if len(ff.GetMetric()) != len(wf.GetMetric()) {
+			t.Fatalf("%s: unexpected number of metrics: wanted %d, found %d", wf.GetName(), len(wf.GetMetric()), len(ff.GetMetric()))
+		}
+		for i, wm := range wf.GetMetric() {
+			fm := ff.GetMetric()[i]
+			if len(fm.GetLabel()) != len(wm.GetLabel()) {
+				t.Fatalf("%s: unexpected labels: wanted %v, found %v", wf.GetName(), wm.GetLabel(), fm.GetLabel())
+			}
+			for j, wl := range wm.GetLabel() {
+				fl := fm.GetLabel()[j]
+				if fl.GetName() != wl.GetName() || fl.GetValue() != wl.GetValue() {
+					t.Fatalf("%s: unexpected label: wanted %s=%q, found %s=%q", wf.GetName(), wl.GetName(), wl.GetValue(), fl.GetName(), fl.GetValue())
+				}
+			}
+			if value(fm) != value(wm) {
+				t.Fatalf("%s: unexpected value: wanted %v, found %v", wf.GetName(), value(wm), value(fm))
+			}
+		}
+	}
+
+	cancel()
+	if err := <-c; err != context.Canceled {
+		t.Fatalf("Unexpected Run error: wanted %v, found %v", context.Canceled, err)
+	}
+	if _, err := os.Stat(w.Path); !os.IsNotExist(err) {
+		t.Fatalf("textfile %s was not removed: %v", w.Path, err)
+	}
+}
+
+// slowGatherer makes the first write last longer than `d`, using
+// `clock`.
+type slowGatherer struct {
+	prometheus.Gatherer
+	clock *testutil.FakeClock
+	d     time.Duration
+	calls chan struct{}
+	n     int
+}
+
+func (g *slowGatherer) Gather() ([]*dto.MetricFamily, error) {
+	if g.n++; g.n == 1 {
+		g.clock.Advance(g.d)
+	}
+	g.calls <- struct{}{}
+	return g.Gatherer.Gather()
+}
+
+func TestTextfileWriter_slow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "booster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	clock := testutil.NewFakeClock(time.Now())
+	interval := time.Second
+	g := &slowGatherer{
+		Gatherer: newRegistry(),
+		clock:    clock,
+		d:        interval * 5 / 2,
+		calls:    make(chan struct{}),
+	}
+	w := &metrics.TextfileWriter{
+		Path:     filepath.Join(dir, "booster.prom"),
+		Interval: interval,
+		Gatherer: g,
+		Clock:    clock,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	// The first write takes 2.5 intervals: the tick that was
+	// delivered meanwhile has to be skipped.
+	<-g.calls
+	select {
+	case <-g.calls:
+		t.Fatalf("Write performed right after a slow one")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Advance(interval / 2)
+	select {
+	case <-g.calls:
+	case <-time.After(time.Second):
+		t.Fatalf("Write not performed at the next tick")
+	}
+}
@@ -19,22 +19,34 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/booster-proj/booster/match"
+	"github.com/booster-proj/booster/source"
 	"github.com/booster-proj/booster/store"
 	"github.com/gorilla/mux"
 )
 
-func makeHealthCheckHandler(info BoosterInfo) http.HandlerFunc {
+func makeHealthCheckHandler(info BoosterInfo, h HealthTracker) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		var stats *source.PollStats
+		if h != nil {
+			st := h.PollStats()
+			stats = &st
+		}
+
 		w.WriteHeader(http.StatusOK)
 		w.Header().Set("Content-Type", "application/json")
 
 		json.NewEncoder(w).Encode(struct {
 			Alive bool `json:"alive"`
 			BoosterInfo
+			Poll *source.PollStats `json:"poll,omitempty"`
 		}{
 			Alive:       true,
 			BoosterInfo: info,
+			Poll:        stats,
 		})
 	}
 }
@@ -45,13 +57,60 @@ func makeSourcesHandler(s *store.SourceStore) http.HandlerFunc {
 		w.Header().Set("Content-Type", "application/json")
 
 		json.NewEncoder(w).Encode(struct {
-			Sources []*store.DummySource `json:"sources"`
+			Sources []*store.SourceRecord `json:"sources"`
 		}{
 			Sources: s.GetSourcesSnapshot(),
 		})
 	}
 }
 
+func makeSourceHealthHandler(h HealthTracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		health, ok := h.Health(id)
+		if !ok {
+			writeError(w, fmt.Errorf("source %v not found", id), http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(health)
+	}
+}
+
+func makeSourceHealthResetHandler(h HealthTracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		if err := h.ResetHealth(id); err != nil {
+			writeError(w, err, http.StatusNotFound)
+			return
+		}
+		health, _ := h.Health(id)
+
+		w.WriteHeader(http.StatusAccepted)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(health)
+	}
+}
+
+func makeHealthResetHandler(h HealthTracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("confirm") != "true" {
+			writeError(w, fmt.Errorf("validation error: resetting the health of all sources requires confirm=true"), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Reset []string `json:"reset"`
+		}{
+			Reset: h.ResetAllHealth(),
+		})
+	}
+}
+
 func makePoliciesHandler(s *store.SourceStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -68,9 +127,9 @@ func makePoliciesHandler(s *store.SourceStore) http.HandlerFunc {
 func makePoliciesDelHandler(s *store.SourceStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := mux.Vars(r)["id"]
-		err := s.DelPolicy(id)
+		err := s.DelPolicy(r.URL.Query().Get("issuer"), id)
 		if err != nil {
-			writeError(w, err, http.StatusNotFound)
+			writePolicyError(w, err, http.StatusNotFound)
 			return
 		}
 
@@ -106,20 +165,89 @@ func makePoliciesBlockHandler(s *store.SourceStore) http.HandlerFunc {
 	}
 }
 
+type StickyPolicyInput struct {
+	PoliciesInput
+	Exceptions []string `json:"exceptions"`
+}
+
 func makePoliciesStickyHandler(s *store.SourceStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
-		var payload PoliciesInput
+		var payload StickyPolicyInput
 		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 			writeError(w, err, http.StatusBadRequest)
 			return
 		}
 
 		p := store.NewStickyPolicy(payload.Issuer, s.QueryBindHistory)
+		p.Pins = s.QueryPin
+		if err := p.Except(payload.Exceptions...); err != nil {
+			writeError(w, fmt.Errorf("validation error: %v", err), http.StatusBadRequest)
+			return
+		}
 		handlePolicy(s, p, w, r)
 	}
 }
 
+func makeStickyPinsHandler(s *store.SourceStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Content-Type", "application/json")
+
+		json.NewEncoder(w).Encode(struct {
+			Pins []store.Pin `json:"pins"`
+		}{
+			Pins: s.GetPinsSnapshot(),
+		})
+	}
+}
+
+func makeStickyPinsAddHandler(s *store.SourceStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		var payload PoliciesInput
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		if payload.SourceID == "" {
+			writeError(w, fmt.Errorf("validation error: source_id cannot be empty"), http.StatusBadRequest)
+			return
+		}
+		if payload.Target == "" {
+			writeError(w, fmt.Errorf("validation error: target cannot be empty"), http.StatusBadRequest)
+			return
+		}
+
+		if _, err := match.Compile(payload.Target); err != nil {
+			writeError(w, fmt.Errorf("validation error: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		pin, err := s.AddPin(payload.Issuer, payload.Target, payload.SourceID)
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pin)
+	}
+}
+
+func makeStickyPinsDelHandler(s *store.SourceStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+		if err := s.DelPin(target); err != nil {
+			writeError(w, err, http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
 type ReservedPolicyInput struct {
 	PoliciesInput
 	Hosts []string `json:"hosts"`
@@ -142,7 +270,11 @@ func makePoliciesReserveHandler(s *store.SourceStore) http.HandlerFunc {
 			return
 		}
 
-		p := store.NewReservedPolicy(payload.Issuer, payload.SourceID, payload.Hosts...)
+		p, err := store.NewReservedPolicy(payload.Issuer, payload.SourceID, payload.Hosts...)
+		if err != nil {
+			writeError(w, fmt.Errorf("validation error: %v", err), http.StatusBadRequest)
+			return
+		}
 		p.Reason = payload.Reason
 		handlePolicy(s, p, w, r)
 	}
@@ -165,7 +297,11 @@ func makePoliciesAvoidHandler(s *store.SourceStore) http.HandlerFunc {
 			return
 		}
 
-		p := store.NewAvoidPolicy(payload.Issuer, payload.SourceID, payload.Target)
+		p, err := store.NewAvoidPolicy(payload.Issuer, payload.SourceID, payload.Target)
+		if err != nil {
+			writeError(w, fmt.Errorf("validation error: %v", err), http.StatusBadRequest)
+			return
+		}
 		p.Reason = payload.Reason
 		handlePolicy(s, p, w, r)
 	}
@@ -173,7 +309,7 @@ func makePoliciesAvoidHandler(s *store.SourceStore) http.HandlerFunc {
 
 func handlePolicy(s *store.SourceStore, p store.Policy, w http.ResponseWriter, r *http.Request) {
 	if err := s.AppendPolicy(p); err != nil {
-		writeError(w, err, http.StatusBadRequest)
+		writePolicyError(w, err, http.StatusBadRequest)
 		return
 	}
 
@@ -182,6 +318,53 @@ func handlePolicy(s *store.SourceStore, p store.Policy, w http.ResponseWriter, r
 	json.NewEncoder(w).Encode(p)
 }
 
+func makePoliciesChurnHandler(s *store.SourceStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Content-Type", "application/json")
+
+		json.NewEncoder(w).Encode(struct {
+			Issuers []store.ChurnRecord `json:"issuers"`
+		}{
+			Issuers: s.GetChurnSnapshot(),
+		})
+	}
+}
+
+// ErrCodePolicyChurn is the error code returned when a policy mutation
+// is refused because its issuer is cooling down.
+const ErrCodePolicyChurn = "policy_churn"
+
+// writePolicyError writes `err` using `code`, unless the error is caused
+// by policy churn, which is reported with http.StatusTooManyRequests, or by
+// conflicting policies, reported with http.StatusConflict.
+func writePolicyError(w http.ResponseWriter, err error, code int) {
+	if cerr, ok := err.(*store.ChurnError); ok {
+		retry := cerr.RetryAfter/time.Second + 1
+		w.Header().Set("Retry-After", strconv.Itoa(int(retry)))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(struct {
+			Error      string    `json:"error"`
+			Code       string    `json:"code"`
+			Issuer     string    `json:"issuer"`
+			RetryAfter int       `json:"retry_after"`
+			Until      time.Time `json:"until"`
+		}{
+			Error:      err.Error(),
+			Code:       ErrCodePolicyChurn,
+			Issuer:     cerr.Issuer,
+			RetryAfter: int(retry),
+			Until:      cerr.Until,
+		})
+		return
+	}
+	if _, ok := err.(*store.ConflictError); ok {
+		code = http.StatusConflict
+	}
+	writeError(w, err, code)
+}
+
 func writeError(w http.ResponseWriter, err error, code int) {
 	w.WriteHeader(code)
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,133 @@
+// Copyright © 2019 KIM KeepInMind GmbH/srl
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package remote_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/booster-proj/booster/core"
+	"github.com/booster-proj/booster/remote"
+	"github.com/booster-proj/booster/store"
+	"github.com/booster-proj/booster/testutil"
+)
+
+func TestPolicies_churn(t *testing.T) {
+	// The clock of the limiter is far from the wall clock: Retry-After
+	// has to be computed using the former.
+	clock := testutil.NewFakeClock(time.Now().Add(-24 * time.Hour))
+	s := store.New(new(core.Balancer))
+	s.SetChurnLimiter(&store.ChurnLimiter{
+		Window:   time.Minute,
+		Hard:     1,
+		CoolDown: 90 * time.Second,
+		Clock:    clock,
+	})
+	r := remote.NewRouter()
+	r.Store = s
+	r.SetupRoutes()
+
+	block := func(id string) *httptest.ResponseRecorder {
+		body := `{"source_id":"` + id + `","issuer":"script"}`
+		req := httptest.NewRequest("POST", "/policies/block.json", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := block("foo"); w.Code != http.StatusCreated {
+		t.Fatalf("Unexpected status code: wanted %d, found %d", http.StatusCreated, w.Code)
+	}
+	clock.Advance(30 * time.Second)
+
+	w := block("bar")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Unexpected status code: wanted %d, found %d", http.StatusTooManyRequests, w.Code)
+	}
+	if retry := w.Header().Get("Retry-After"); retry != "61" {
+		t.Fatalf("Unexpected Retry-After: wanted 61, found %s", retry)
+	}
+	var payload struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Code != remote.ErrCodePolicyChurn {
+		t.Fatalf("Unexpected error code: wanted %s, found %s", remote.ErrCodePolicyChurn, payload.Code)
+	}
+
+	// Deletions are accepted while cooling down.
+	req := httptest.NewRequest("DELETE", "/policies/block_foo.json?issuer=script", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code: wanted %d, found %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestPolicies_conflict(t *testing.T) {
+	r := remote.NewRouter()
+	r.Store = store.New(new(core.Balancer))
+	r.SetupRoutes()
+
+	reserve := func(id string, hosts string) int {
+		body := `{"source_id":"` + id + `","issuer":"script","hosts":[` + hosts + `]}`
+		req := httptest.NewRequest("POST", "/policies/reserve.json", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := reserve("foo", `"10.8.0.0/16"`); code != http.StatusCreated {
+		t.Fatalf("Unexpected status code: wanted %d, found %d", http.StatusCreated, code)
+	}
+	// Overlapping, but more specific.
+	if code := reserve("bar", `"10.8.3.0/24"`); code != http.StatusCreated {
+		t.Fatalf("Unexpected status code: wanted %d, found %d", http.StatusCreated, code)
+	}
+	if code := reserve("baz", `"10.8.3.0/24"`); code != http.StatusConflict {
+		t.Fatalf("Unexpected status code: wanted %d, found %d", http.StatusConflict, code)
+	}
+}
+
+func TestPins_delete(t *testing.T) {
+	r := remote.NewRouter()
+	r.Store = store.New(new(core.Balancer))
+	r.SetupRoutes()
+
+	body := `{"source_id":"foo","target":"host:443","issuer":"T"}`
+	req := httptest.NewRequest("POST", "/policies/sticky/pins.json", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Unexpected status code: wanted %d, found %d", http.StatusCreated, w.Code)
+	}
+
+	req = httptest.NewRequest("DELETE", "/policies/sticky/pins.json?target=HOST:443", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code: wanted %d, found %d", http.StatusOK, w.Code)
+	}
+	if pins := r.Store.GetPinsSnapshot(); len(pins) != 0 {
+		t.Fatalf("Unexpected pins: %+v", pins)
+	}
+}
@@ -24,6 +24,7 @@ package remote
 import (
 	"net/http"
 
+	"github.com/booster-proj/booster/source"
 	"github.com/booster-proj/booster/store"
 	"github.com/gorilla/mux"
 )
@@ -50,6 +51,17 @@ type Router struct {
 	Store           *store.SourceStore
 	Info            BoosterInfo
 	MetricsProvider http.Handler
+	Health          HealthTracker
+}
+
+// HealthTracker describes an entity that keeps track of the health
+// of the sources, and is able to reset it. source.Listener is an
+// HealthTracker.
+type HealthTracker interface {
+	Health(id string) (source.Health, bool)
+	ResetHealth(id string) error
+	ResetAllHealth() []string
+	PollStats() source.PollStats
 }
 
 // NewRouter creates a new router instance. Router should not
@@ -64,18 +76,27 @@ func NewRouter() *Router {
 // properly.
 func (r *Router) SetupRoutes() {
 	router := r.r
-	router.HandleFunc("/health.json", makeHealthCheckHandler(r.Info))
+	router.HandleFunc("/health.json", makeHealthCheckHandler(r.Info, r.Health))
 	if store := r.Store; store != nil {
 		router.HandleFunc("/sources.json", makeSourcesHandler(store))
 
 		router.HandleFunc("/policies.json", makePoliciesHandler(store))
+		router.HandleFunc("/policies/churn.json", makePoliciesChurnHandler(store)).Methods("GET")
 		router.HandleFunc("/policies/{id}.json", makePoliciesDelHandler(store)).Methods("DELETE")
 
 		router.HandleFunc("/policies/block.json", makePoliciesBlockHandler(store)).Methods("POST")
 		router.HandleFunc("/policies/sticky.json", makePoliciesStickyHandler(store)).Methods("POST")
+		router.HandleFunc("/policies/sticky/pins.json", makeStickyPinsHandler(store)).Methods("GET")
+		router.HandleFunc("/policies/sticky/pins.json", makeStickyPinsAddHandler(store)).Methods("POST")
+		router.HandleFunc("/policies/sticky/pins.json", makeStickyPinsDelHandler(store)).Methods("DELETE")
 		router.HandleFunc("/policies/reserve.json", makePoliciesReserveHandler(store)).Methods("POST")
 		router.HandleFunc("/policies/avoid.json", makePoliciesAvoidHandler(store)).Methods("POST")
 	}
+	if health := r.Health; health != nil {
+		router.HandleFunc("/sources/{id}/health.json", makeSourceHealthHandler(health)).Methods("GET")
+		router.HandleFunc("/sources/{id}/health/reset.json", makeSourceHealthResetHandler(health)).Methods("POST")
+		router.HandleFunc("/health/reset.json", makeHealthResetHandler(health)).Methods("POST")
+	}
 	if handler := r.MetricsProvider; handler != nil {
 		router.Handle("/metrics", handler)
 	}
@@ -0,0 +1,221 @@
+// Copyright © 2019 KIM KeepInMind GmbH/srl
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package source
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/booster-proj/booster/core"
+)
+
+// DefaultCheckTargets are the targets used to check the connection
+// of the sources when none are configured.
+var DefaultCheckTargets = []string{"google.com:80"}
+
+var (
+	// CheckDialTimeout is the time a Medium confidence check waits
+	// for a connection to a target.
+	CheckDialTimeout = time.Millisecond * 500
+	// CheckRequestTimeout is the time a High confidence check waits
+	// for the response of a target.
+	CheckRequestTimeout = time.Second * 2
+)
+
+// CheckConfig configures how the Listener checks the connection of
+// the sources.
+type CheckConfig struct {
+	// Targets are either "host:port" addresses or http(s) URLs. A
+	// Medium confidence check connects to the address of a target,
+	// a High confidence one performs a GET request to its URL (or
+	// "http://host:port/" for plain addresses). A check succeeds as
+	// soon as one target is reachable. Defaults to
+	// DefaultCheckTargets. Only the sources found on the network
+	// interfaces use them, see StaticProvider for the declared ones.
+	Targets []string
+
+	// Confidence is the level used to check the sources. The Listener
+	// uses High when no CheckConfig is provided.
+	Confidence Confidence
+
+	// Sources maps source identifiers to the confidence level used
+	// to check them in place of Confidence, e.g. to avoid requests
+	// on metered sources.
+	Sources map[string]Confidence
+
+	// Interval, if positive, is how often the stored sources are
+	// checked again, so that the ones that lost their connection
+	// are removed even if they do not produce dial errors.
+	Interval time.Duration
+
+	// Intervals maps source identifiers to how often they are
+	// checked again while stored, overriding both Interval and the
+	// interval hinted by the provider, see Interface.CheckInterval.
+	// A zero value disables the periodic checks of the source.
+	Intervals map[string]time.Duration
+
+	// Concurrency is the maximum number of checks running at the
+	// same time during a poll. Defaults to DefaultCheckConcurrency.
+	Concurrency int
+}
+
+// DefaultCheckConcurrency is the default of CheckConfig.Concurrency.
+const DefaultCheckConcurrency = 4
+
+func (c *CheckConfig) concurrency() int {
+	if c == nil || c.Concurrency <= 0 {
+		return DefaultCheckConcurrency
+	}
+	return c.Concurrency
+}
+
+// Validate returns an error if any of the targets is not valid.
+func (c *CheckConfig) Validate() error {
+	for _, v := range c.Targets {
+		if _, err := parseCheckTarget(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// level returns the confidence level used to check source `id`.
+func (c *CheckConfig) level(id string) Confidence {
+	if c == nil {
+		return High
+	}
+	if l, ok := c.Sources[id]; ok {
+		return l
+	}
+	return c.Confidence
+}
+
+type checkTarget struct {
+	addr string // "host:port"
+	url  string
+}
+
+func parseCheckTarget(s string) (checkTarget, error) {
+	if !strings.Contains(s, "://") {
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			return checkTarget{}, fmt.Errorf("check: invalid target %q: %v", s, err)
+		}
+		return checkTarget{addr: s, url: "http://" + s + "/"}, nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return checkTarget{}, fmt.Errorf("check: invalid target %q: %v", s, err)
+	}
+	var port string
+	switch u.Scheme {
+	case "http":
+		port = "80"
+	case "https":
+		port = "443"
+	default:
+		return checkTarget{}, fmt.Errorf("check: invalid target %q: neither a host:port address nor an http(s) URL", s)
+	}
+	if u.Hostname() == "" {
+		return checkTarget{}, fmt.Errorf("check: invalid target %q: missing host", s)
+	}
+	if p := u.Port(); p != "" {
+		port = p
+	}
+	return checkTarget{addr: net.JoinHostPort(u.Hostname(), port), url: s}, nil
+}
+
+// CheckConn checks the connection provided by `src` against `targets`
+// with confidence `level`, returning nil as soon as one of them is
+// reachable. Low confidence checks do not use the network, and always
+// succeed.
+func CheckConn(ctx context.Context, src core.Source, targets []string, level Confidence) error {
+	if level <= Low {
+		return nil
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("check: no targets to check source %s with", src.ID())
+	}
+
+	var err error
+	for _, v := range targets {
+		var t checkTarget
+		if t, err = parseCheckTarget(v); err != nil {
+			continue
+		}
+		if level == Medium {
+			err = checkDial(ctx, src, t)
+		} else {
+			err = checkRequest(ctx, src, t)
+		}
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+func checkDial(ctx context.Context, src core.Source, t checkTarget) error {
+	ctx, cancel := context.WithTimeout(ctx, CheckDialTimeout)
+	defer cancel()
+
+	conn, err := src.DialContext(ctx, "tcp", t.addr)
+	if err != nil {
+		return fmt.Errorf("unable to dial connection to %s using source %s: %v", t.addr, src.ID(), err)
+	}
+	conn.Close()
+	return nil
+}
+
+func checkRequest(ctx context.Context, src core.Source, t checkTarget) error {
+	ctx, cancel := context.WithTimeout(ctx, CheckRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodGet, t.url, nil)
+	if err != nil {
+		return fmt.Errorf("check: invalid request to %s: %v", t.url, err)
+	}
+	tr := &http.Transport{
+		DialContext:       src.DialContext,
+		DisableKeepAlives: true,
+	}
+	defer tr.CloseIdleConnections()
+	client := &http.Client{
+		Transport: tr,
+		// Any response proves the connection, redirects included.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("unable to perform request to %s using source %s: %v", t.url, src.ID(), err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("request to %s using source %s failed: %s", t.url, src.ID(), resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright © 2019 KIM KeepInMind GmbH/srl
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package source_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/booster-proj/booster/source"
+)
+
+// netSource dials connections with the default dialer, counting them.
+type netSource struct {
+	mock
+	dials int
+}
+
+func (s *netSource) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	s.dials++
+	var d net.Dialer
+	return d.DialContext(ctx, network, address)
+}
+
+func TestCheckConn(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/elsewhere", http.StatusFound)
+	}))
+	defer ok.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer broken.Close()
+
+	// Find an address nobody is listening on.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := ln.Addr().String()
+	ln.Close()
+
+	okAddr := ok.Listener.Addr().String()
+	tt := []struct {
+		level   source.Confidence
+		targets []string
+		ok      bool
+		dials   int
+	}{
+		{level: source.Low, targets: []string{closed}, ok: true, dials: 0},
+		{level: source.Medium, targets: []string{okAddr}, ok: true, dials: 1},
+		{level: source.Medium, targets: []string{closed}, ok: false, dials: 1},
+		{level: source.Medium, targets: []string{broken.URL}, ok: true, dials: 1},
+		{level: source.Medium, targets: []string{closed, ok.URL}, ok: true, dials: 2},
+		{level: source.High, targets: []string{ok.URL}, ok: true, dials: 1},
+		{level: source.High, targets: []string{okAddr}, ok: true, dials: 1},
+		{level: source.High, targets: []string{broken.URL}, ok: false, dials: 1},
+		{level: source.High, targets: []string{closed}, ok: false, dials: 1},
+		{level: source.High, targets: []string{broken.URL, ok.URL + "/path"}, ok: true, dials: 2},
+		{level: source.High, targets: []string{}, ok: false, dials: 0},
+	}
+
+	for i, v := range tt {
+		src := &netSource{mock: mock{id: "foo"}}
+		err := source.CheckConn(context.Background(), src, v.targets, v.level)
+		if (err == nil) != v.ok {
+			t.Fatalf("%d: Unexpected check result with confidence %v on %v: %v", i, v.level, v.targets, err)
+		}
+		if src.dials != v.dials {
+			t.Fatalf("%d: Unexpected number of dials: wanted %d, found %d", i, v.dials, src.dials)
+		}
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	valid := []string{"example.com:80", "[::1]:443", "http://example.com", "https://example.com:8443/generate_204"}
+	for _, v := range valid {
+		c := &source.CheckConfig{Targets: []string{v}}
+		if err := c.Validate(); err != nil {
+			t.Fatalf("Unexpected error with target %s: %v", v, err)
+		}
+	}
+
+	invalid := []string{"example.com", "ftp://example.com", "http://", "http://[::1"}
+	for _, v := range invalid {
+		c := &source.CheckConfig{Targets: []string{v}}
+		if err := c.Validate(); err == nil {
+			t.Fatalf("Expected error with target %s", v)
+		}
+	}
+}
+
+func TestParseConfidence(t *testing.T) {
+	for _, v := range []source.Confidence{source.Low, source.Medium, source.High} {
+		c, err := source.ParseConfidence(v.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c != v {
+			t.Fatalf("Unexpected confidence: wanted %v, found %v", v, c)
+		}
+	}
+	if _, err := source.ParseConfidence("extreme"); err == nil {
+		t.Fatalf("Expected error with unknown confidence")
+	}
+}
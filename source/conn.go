@@ -16,6 +16,7 @@
 package source
 
 import (
+	"fmt"
 	"net"
 	"time"
 )
@@ -119,3 +120,24 @@ func (c *Conn) Close() error {
 	c.closed = true
 	return c.Conn.Close()
 }
+
+// CloseWrite shuts down the writing side of the underlying connection,
+// if it supports half-close, e.g. *net.TCPConn. The peer receives an EOF
+// while the connection can still be read.
+func (c *Conn) CloseWrite() error {
+	cw, ok := c.Conn.(interface{ CloseWrite() error })
+	if !ok {
+		return fmt.Errorf("conn: %T does not support half-close", c.Conn)
+	}
+	return cw.CloseWrite()
+}
+
+// CloseRead shuts down the reading side of the underlying connection,
+// if it supports half-close.
+func (c *Conn) CloseRead() error {
+	cr, ok := c.Conn.(interface{ CloseRead() error })
+	if !ok {
+		return fmt.Errorf("conn: %T does not support half-close", c.Conn)
+	}
+	return cr.CloseRead()
+}
@@ -0,0 +1,86 @@
+// Copyright © 2019 KIM KeepInMind GmbH/srl
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package source_test
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/booster-proj/booster/source"
+)
+
+func TestConn_closeWrite(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The server reads everything until EOF, then replies.
+	errc := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer conn.Close()
+		b, err := ioutil.ReadAll(conn)
+		if err != nil {
+			errc <- err
+			return
+		}
+		_, err = conn.Write(append([]byte("got "), b...))
+		errc <- err
+	}()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := &source.Conn{Conn: c}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reading must still be possible after the half-close.
+	b, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "got ping" {
+		t.Fatalf("Unexpected reply: wanted \"got ping\", found %q", b)
+	}
+}
+
+func TestConn_closeWriteUnsupported(t *testing.T) {
+	c, _ := net.Pipe()
+	conn := &source.Conn{Conn: c}
+	defer conn.Close()
+
+	if err := conn.CloseWrite(); err == nil {
+		t.Fatalf("Expected error on connection without half-close support")
+	}
+}
@@ -63,6 +63,7 @@ func (i *Interface) dialContext(ctx context.Context, network, address string) (n
 	}
 
 	d := &net.Dialer{
+		KeepAlive: i.KeepAlive,
 		Control: func(network, address string, c syscall.RawConn) error {
 			return c.Control(func(fd uintptr) {
 				if err := unix.Bind(int(fd), addr); err != nil {
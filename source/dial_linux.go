@@ -28,6 +28,7 @@ import (
 
 func (i *Interface) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
 	d := &net.Dialer{
+		KeepAlive: i.KeepAlive,
 		Control: func(network, address string, c syscall.RawConn) error {
 			return c.Control(func(fd uintptr) {
 				if err := unix.BindToDevice(int(fd), i.ID()); err != nil {
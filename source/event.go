@@ -0,0 +1,132 @@
+// Copyright © 2019 KIM KeepInMind GmbH/srl
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package source
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventKind tells what happened to a source.
+type EventKind int
+
+const (
+	// SourceAdded is emitted when a source is stored.
+	SourceAdded EventKind = iota
+	// SourceRemoved is emitted when a source is removed from the
+	// store, either because it is no longer provided or because it
+	// failed a check.
+	SourceRemoved
+	// SourceCheckFailed is emitted when a source does not pass a
+	// check, both when it is new and when it is already stored.
+	SourceCheckFailed
+)
+
+var eventKindNames = []string{"source_added", "source_removed", "source_check_failed"}
+
+func (k EventKind) String() string {
+	if k < SourceAdded || k > SourceCheckFailed {
+		return "unknown"
+	}
+	return eventKindNames[k]
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (k EventKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// Event describes a change in the sources handled by the Listener.
+type Event struct {
+	Kind   EventKind `json:"kind"`
+	Source string    `json:"source"`
+	Reason string    `json:"reason,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// DefaultEventBuffer is the number of events a Subscription buffers
+// when no size is provided.
+const DefaultEventBuffer = 64
+
+// Subscription delivers the events emitted by a Listener.
+type Subscription struct {
+	// C is the channel the events are delivered on. It is closed
+	// by Close.
+	C <-chan Event
+
+	c       chan Event
+	l       *Listener
+	dropped uint64 // accessed atomically.
+}
+
+// Dropped returns the number of events that were not delivered
+// because the buffer of the subscription was full.
+func (s *Subscription) Dropped() int {
+	return int(atomic.LoadUint64(&s.dropped))
+}
+
+// Close stops the delivery of events, closing C.
+func (s *Subscription) Close() {
+	s.l.events.Lock()
+	defer s.l.events.Unlock()
+
+	if _, ok := s.l.events.subs[s]; !ok {
+		return
+	}
+	delete(s.l.events.subs, s)
+	close(s.c)
+}
+
+type subscribers struct {
+	sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+// Subscribe returns a Subscription to the events emitted by the
+// Listener, buffering up to `size` of them (DefaultEventBuffer if
+// not positive). Events are never waited for: the ones that do not
+// fit in the buffer are dropped and counted. Close the subscription
+// when it is no longer needed.
+func (l *Listener) Subscribe(size int) *Subscription {
+	if size <= 0 {
+		size = DefaultEventBuffer
+	}
+	c := make(chan Event, size)
+	s := &Subscription{C: c, c: c, l: l}
+
+	l.events.Lock()
+	defer l.events.Unlock()
+	if l.events.subs == nil {
+		l.events.subs = make(map[*Subscription]struct{})
+	}
+	l.events.subs[s] = struct{}{}
+	return s
+}
+
+func (l *Listener) emit(kind EventKind, id, reason string) {
+	e := Event{Kind: kind, Source: id, Reason: reason, At: l.clock.Now()}
+
+	l.events.Lock()
+	defer l.events.Unlock()
+	for s := range l.events.subs {
+		select {
+		case s.c <- e:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
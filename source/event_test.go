@@ -0,0 +1,108 @@
+// Copyright © 2019 KIM KeepInMind GmbH/srl
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package source_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/booster-proj/booster/source"
+)
+
+func TestSubscribe(t *testing.T) {
+	en0 := &mock{id: "en0", active: true}
+	en1 := &mock{id: "en1", active: false}
+	p := &mockProvider{sources: []*mock{en0, en1}}
+	l := source.NewListener(source.Config{Store: new(storage), Provider: p})
+	sub := l.Subscribe(0)
+	defer sub.Close()
+
+	type event struct {
+		kind source.EventKind
+		id   string
+	}
+	poll := func(want ...event) {
+		if err := l.Poll(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		for i, w := range want {
+			select {
+			case e := <-sub.C:
+				if e.Kind != w.kind || e.Source != w.id || e.At.IsZero() {
+					t.Fatalf("%d: Unexpected event: wanted %v %s, found %+v", i, w.kind, w.id, e)
+				}
+				if e.Kind != source.SourceAdded && e.Reason == "" {
+					t.Fatalf("%d: Event %+v has no reason", i, e)
+				}
+			default:
+				t.Fatalf("%d: Missing event: wanted %v %s", i, w.kind, w.id)
+			}
+		}
+		select {
+		case e := <-sub.C:
+			t.Fatalf("Unexpected event: %+v", e)
+		default:
+		}
+	}
+
+	poll(event{source.SourceCheckFailed, "en1"}, event{source.SourceAdded, "en0"})
+
+	// A stored source that fails its check is removed.
+	en0.active = false
+	if err := l.ResetHealth("en0"); err != nil {
+		t.Fatal(err)
+	}
+	poll(event{source.SourceCheckFailed, "en1"}, event{source.SourceCheckFailed, "en0"}, event{source.SourceRemoved, "en0"})
+
+	// A stored source that is no longer provided is removed.
+	en0.active = true
+	p.sources = []*mock{en0}
+	poll(event{source.SourceAdded, "en0"})
+	p.sources = nil
+	poll(event{source.SourceRemoved, "en0"})
+
+	if n := sub.Dropped(); n != 0 {
+		t.Fatalf("Unexpected dropped events: %d", n)
+	}
+}
+
+func TestSubscribe_drop(t *testing.T) {
+	p := &mockProvider{sources: []*mock{{id: "en0", active: true}, {id: "en1", active: true}}}
+	l := source.NewListener(source.Config{Store: new(storage), Provider: p})
+	slow := l.Subscribe(1)
+	fast := l.Subscribe(2)
+	defer fast.Close()
+
+	if err := l.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := slow.Dropped(); n != 1 {
+		t.Fatalf("Unexpected dropped events: wanted 1, found %d", n)
+	}
+	if n := fast.Dropped(); n != 0 {
+		t.Fatalf("Unexpected dropped events: wanted 0, found %d", n)
+	}
+
+	slow.Close()
+	slow.Close() // closing twice is harmless.
+	<-slow.C
+	if _, ok := <-slow.C; ok {
+		t.Fatalf("Channel of closed subscription is still open")
+	}
+	if err := l.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright © 2019 KIM KeepInMind GmbH/srl
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package source
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/booster-proj/booster/core"
+	"upspin.io/log"
+)
+
+// CheckResult is the outcome of a check performed by the Listener
+// on a source.
+type CheckResult struct {
+	At         time.Time     `json:"at"`
+	Confidence Confidence    `json:"confidence"`
+	Latency    time.Duration `json:"latency_ns"`
+	Err        string        `json:"error,omitempty"`
+}
+
+// CheckLatencyExporter is implemented by the metrics exporters that
+// collect the time taken by the successful checks of each source.
+type CheckLatencyExporter interface {
+	SetCheckLatency(labels map[string]string, d time.Duration)
+}
+
+// checkRecorder is implemented by the sources that keep track of
+// their last successful check, see Interface.LastCheck.
+type checkRecorder interface {
+	recordCheck(at time.Time, d time.Duration)
+}
+
+// HookErrInfo describes the dial errors collected by the Hooker that
+// are waiting to be handled.
+type HookErrInfo struct {
+	ReceivedAt time.Time `json:"received_at"`
+	Network    string    `json:"network"`
+	Address    string    `json:"address"`
+	Err        string    `json:"error"`
+	// Count is the number of dial errors collected within the
+	// window of the Hooker, ReceivedAt refers to the last one.
+	Count int `json:"count"`
+}
+
+// Health is the health picture of a source, as seen by the Listener.
+type Health struct {
+	ID string `json:"id"`
+
+	// Stored is true when the source is in the store, i.e. it is
+	// serving traffic.
+	Stored bool `json:"stored"`
+
+	// HookErr describes the dial errors collected for the source,
+	// which are handled by the next poll once they cross the
+	// threshold of the Hooker.
+	HookErr *HookErrInfo `json:"hook_error,omitempty"`
+
+	// LastCheck is the result of the last check performed on
+	// the source.
+	LastCheck *CheckResult `json:"last_check,omitempty"`
+
+	// RecheckPending is true when a reset requested a check on
+	// the source that has not been performed yet.
+	RecheckPending bool `json:"recheck_pending"`
+}
+
+// Health returns the health picture of source `id`. Returns false
+// when the Listener knows nothing about it.
+func (l *Listener) Health(id string) (Health, bool) {
+	h := Health{ID: id}
+	l.s.Do(func(src core.Source) {
+		if src.ID() == id {
+			h.Stored = true
+		}
+	})
+	if err, n := l.h.peek(id); err != nil {
+		h.HookErr = &HookErrInfo{
+			ReceivedAt: err.receivedAt,
+			Network:    err.network,
+			Address:    err.address,
+			Err:        err.err.Error(),
+			Count:      n,
+		}
+	}
+
+	l.mux.Lock()
+	if res, ok := l.checks[id]; ok {
+		h.LastCheck = &res
+	}
+	h.RecheckPending = l.pending[id]
+	l.mux.Unlock()
+
+	known := h.Stored || h.HookErr != nil || h.LastCheck != nil || h.RecheckPending
+	return h, known
+}
+
+// ResetHealth clears the hook error collected for source `id` and
+// requests a new check on it, which is performed as soon as possible
+// by Run. The reset does not make the source healthy: a stored source
+// is removed if the check fails, and a source that is not stored is
+// added only if the check passes.
+func (l *Listener) ResetHealth(id string) error {
+	if _, ok := l.Health(id); !ok {
+		return fmt.Errorf("listener: source %v not found", id)
+	}
+	l.reset(id)
+	l.wakeup()
+	return nil
+}
+
+// ResetAllHealth is like ResetHealth, but acts on every source known
+// to the Listener. Returns the identifiers of the sources reset.
+func (l *Listener) ResetAllHealth() []string {
+	ids := make(map[string]bool)
+	l.s.Do(func(src core.Source) {
+		ids[src.ID()] = true
+	})
+	for _, id := range l.h.IDs() {
+		ids[id] = true
+	}
+	l.mux.Lock()
+	for id := range l.checks {
+		ids[id] = true
+	}
+	l.mux.Unlock()
+
+	acc := make([]string, 0, len(ids))
+	for id := range ids {
+		l.reset(id)
+		acc = append(acc, id)
+	}
+	sort.Strings(acc)
+	l.wakeup()
+	return acc
+}
+
+func (l *Listener) reset(id string) {
+	log.Info.Printf("Listener: resetting health of %v", id)
+	l.h.Clear(id)
+
+	l.mux.Lock()
+	if l.pending == nil {
+		l.pending = make(map[string]bool)
+	}
+	l.pending[id] = true
+	l.mux.Unlock()
+}
+
+// wakeup makes Run poll without waiting for PollInterval to expire.
+func (l *Listener) wakeup() {
+	select {
+	case l.trigger <- struct{}{}:
+	default:
+		// A poll is already scheduled.
+	}
+}
+
+// takePending returns the sources that have to be checked again,
+// clearing the list.
+func (l *Listener) takePending() map[string]bool {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	p := l.pending
+	l.pending = nil
+	return p
+}
+
+// check checks `src` with the confidence configured for it, recording
+// the result.
+func (l *Listener) check(ctx context.Context, src core.Source) error {
+	level := l.checkConf.level(src.ID())
+	start := l.clock.Now()
+	err := l.Check(ctx, src, level)
+	now := l.clock.Now()
+	res := CheckResult{At: now, Confidence: level, Latency: now.Sub(start)}
+	if err != nil {
+		res.Err = err.Error()
+	} else {
+		if r, ok := src.(checkRecorder); ok {
+			r.recordCheck(now, res.Latency)
+		}
+		if exp, ok := l.exp.(CheckLatencyExporter); ok {
+			exp.SetCheckLatency(map[string]string{"source": src.ID()}, res.Latency)
+		}
+	}
+
+	l.mux.Lock()
+	if l.checks == nil {
+		l.checks = make(map[string]CheckResult)
+	}
+	l.checks[src.ID()] = res
+	l.mux.Unlock()
+
+	return err
+}
+
+// CheckIntervalHinter is implemented by the sources that have to be
+// checked again periodically while stored, see Interface.CheckInterval.
+type CheckIntervalHinter interface {
+	CheckIntervalHint() time.Duration
+}
+
+// checkInterval returns how often `src` has to be checked, or zero
+// if it has not to be checked periodically.
+func (l *Listener) checkInterval(src core.Source) time.Duration {
+	c := l.checkConf
+	if c != nil {
+		if d, ok := c.Intervals[src.ID()]; ok {
+			return d
+		}
+	}
+	if h, ok := src.(CheckIntervalHinter); ok {
+		if d := h.CheckIntervalHint(); d > 0 {
+			return d
+		}
+	}
+	if c != nil {
+		return c.Interval
+	}
+	return 0
+}
+
+// checkAll checks `srcs`, running at most the configured number of
+// checks at the same time. The errors are returned in the same order
+// of the sources.
+func (l *Listener) checkAll(ctx context.Context, srcs []core.Source) []error {
+	errs := make([]error, len(srcs))
+	sem := make(chan struct{}, l.checkConf.concurrency())
+	var wg sync.WaitGroup
+	for i, v := range srcs {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, src core.Source) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			errs[i] = l.check(ctx, src)
+		}(i, v)
+	}
+	wg.Wait()
+	return errs
+}
+
+// isDue reports whether the check interval of `src` expired at `now`.
+func (l *Listener) isDue(src core.Source, now time.Time) bool {
+	d := l.checkInterval(src)
+	if d <= 0 {
+		return false
+	}
+
+	l.mux.Lock()
+	res, ok := l.checks[src.ID()]
+	l.mux.Unlock()
+	return !ok || !now.Before(res.At.Add(d))
+}
+
+// untilDue returns the time left before the first stored source is due
+// for a check, or zero if none has a check interval.
+func (l *Listener) untilDue() time.Duration {
+	now := l.clock.Now()
+	var min time.Duration
+	for _, src := range l.StoredSources() {
+		d := l.checkInterval(src)
+		if d <= 0 {
+			continue
+		}
+		l.mux.Lock()
+		res, ok := l.checks[src.ID()]
+		l.mux.Unlock()
+		left := time.Duration(0)
+		if ok {
+			left = res.At.Add(d).Sub(now)
+		}
+		if left < time.Millisecond {
+			left = time.Millisecond
+		}
+		if min == 0 || left < min {
+			min = left
+		}
+	}
+	return min
+}
+
+// forget removes the check results recorded for `src`.
+func (l *Listener) forget(src core.Source) {
+	l.mux.Lock()
+	delete(l.checks, src.ID())
+	l.mux.Unlock()
+}
@@ -17,6 +17,7 @@ package source
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"sync"
 	"time"
@@ -47,11 +48,33 @@ type Interface struct {
 	// dialer is not able to create a network connection.
 	OnDialErr DialHook
 
+	// KeepAlive is the interval between the TCP keep-alive probes
+	// sent on the connections dialed, with the semantics of
+	// net.Dialer.KeepAlive: zero uses the system default, a
+	// negative value disables them.
+	KeepAlive time.Duration
+
+	// LocalAddr, if not nil, is the local address the connections
+	// are bound to in place of the interface's device. This is how
+	// the sources declared by a StaticProvider dial.
+	LocalAddr net.IP
+
+	// CheckInterval, if positive, tells the Listener to check the
+	// connection of the interface again each CheckInterval while it
+	// is stored.
+	CheckInterval time.Duration
+
 	metrics struct {
 		sync.Mutex
 		exporter MetricsExporter
 	}
 
+	check struct {
+		sync.Mutex
+		at      time.Time
+		latency time.Duration
+	}
+
 	conns *conns
 }
 
@@ -64,6 +87,29 @@ func (i *Interface) SetMetricsExporter(exp MetricsExporter) {
 	i.metrics.exporter = exp
 }
 
+// LastCheck returns when the connection of the interface was last
+// checked successfully by the Listener, and how long the check took.
+// The time is zero if no check succeeded yet.
+func (i *Interface) LastCheck() (time.Time, time.Duration) {
+	i.check.Lock()
+	defer i.check.Unlock()
+
+	return i.check.at, i.check.latency
+}
+
+func (i *Interface) recordCheck(at time.Time, d time.Duration) {
+	i.check.Lock()
+	defer i.check.Unlock()
+
+	i.check.at = at
+	i.check.latency = d
+}
+
+// CheckIntervalHint implements CheckIntervalHinter.
+func (i *Interface) CheckIntervalHint() time.Duration {
+	return i.CheckInterval
+}
+
 // ID implements the core.Source interface.
 func (i *Interface) ID() string {
 	return i.ifi.Name
@@ -74,9 +120,7 @@ func (i *Interface) ID() string {
 // `Follow` is called is called on the net.Conn before returning it.
 // This function dials the connection using the interface's actual device as mean.
 func (i *Interface) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
-	// Implementations of the `dialContext` function can be found
-	// in the {darwin, linux, windows}_dial.go files.
-	conn, err := i.dialContext(ctx, network, address)
+	conn, err := i.dial(ctx, network, address)
 	if err != nil {
 		if f := i.OnDialErr; f != nil {
 			f(i.ID(), network, address, err)
@@ -87,6 +131,25 @@ func (i *Interface) DialContext(ctx context.Context, network, address string) (n
 	return i.Follow(conn), nil
 }
 
+func (i *Interface) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	if i.LocalAddr == nil {
+		// Implementations of the `dialContext` function can be found
+		// in the {darwin, linux, windows}_dial.go files.
+		return i.dialContext(ctx, network, address)
+	}
+
+	d := &net.Dialer{KeepAlive: i.KeepAlive}
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		d.LocalAddr = &net.TCPAddr{IP: i.LocalAddr}
+	case "udp", "udp4", "udp6":
+		d.LocalAddr = &net.UDPAddr{IP: i.LocalAddr}
+	default:
+		return nil, fmt.Errorf("source %s: unable to bind network %s to a local address", i.ID(), network)
+	}
+	return d.DialContext(ctx, network, address)
+}
+
 // Follow wraps the net.Conn around a Conn type, and keeps track of its
 // callbacks, sending the metrics collected with the OnRead and OnWrite
 // hooks.
@@ -36,6 +36,14 @@ type Store interface {
 	Do(func(core.Source))
 }
 
+// Replacer is implemented by stores that are able to replace their
+// whole content with a single operation. When the Listener's store is
+// a Replacer, Poll uses it to apply its changes, so that the set of
+// stored sources is never observed half way through an update.
+type Replacer interface {
+	ReplaceAll(...core.Source)
+}
+
 // Provider describes a service that is capable of providing sources
 // and checking their effective internet connection using a defined
 // level of confidence.
@@ -52,8 +60,30 @@ type Listener struct {
 	s Store
 	// Hook errors handler.
 	h *Hooker
+	// Time source.
+	clock core.Clock
+	// Wakes up Run before PollInterval expires.
+	trigger chan struct{}
+
+	pollInterval time.Duration
+	pollTimeout  time.Duration
+
+	mux     sync.Mutex
+	checks  map[string]CheckResult // last check result mapped by source ID.
+	pending map[string]bool        // sources to check again in the next poll.
+
+	adaptive  *AdaptiveInterval
+	exp       MetricsExporter
+	checkConf *CheckConfig
+	events    subscribers
+	stats     struct {
+		sync.Mutex
+		PollStats
+	}
 }
 
+// PollInterval and PollTimeout are the defaults of the Config
+// fields with the same name.
 var PollInterval = time.Second * 3
 var PollTimeout = time.Second * 5
 
@@ -61,27 +91,137 @@ type Config struct {
 	Store           Store
 	Provider        Provider
 	MetricsExporter MetricsExporter
+
+	// Clock is used to measure time. Defaults to core.SystemClock.
+	Clock core.Clock
+
+	// PollInterval is the time Run waits between polls, and
+	// PollTimeout the maximum duration of each of them. They default
+	// to the package variables with the same name.
+	PollInterval time.Duration
+	PollTimeout  time.Duration
+
+	// KeepAlive configures the TCP keep-alive probes of the
+	// interfaces provided, see Interface.KeepAlive.
+	KeepAlive time.Duration
+
+	// AdaptivePoll, if set, makes Run adapt the interval between
+	// polls to how often they find changes. Otherwise, PollInterval
+	// is used.
+	AdaptivePoll *AdaptiveInterval
+
+	// SourcesFile, if set, is the path of the file read by a
+	// StaticProvider, used in place of the network interfaces.
+	SourcesFile string
+
+	// HookErrThreshold and HookErrWindow configure the Hooker: a
+	// stored source is checked again only after it produced
+	// HookErrThreshold dial errors within HookErrWindow. By default
+	// the first dial error triggers the check.
+	HookErrThreshold int
+	HookErrWindow    time.Duration
+
+	// Check configures the checks performed on the sources. When
+	// nil, they are checked with High confidence against
+	// DefaultCheckTargets.
+	Check *CheckConfig
+}
+
+// AdaptiveInterval describes an interval that grows while nothing
+// changes: each poll that finds no change multiplies it by Factor, up
+// to Max. A change, or an explicit wakeup, resets the interval to Min.
+type AdaptiveInterval struct {
+	Min    time.Duration
+	Max    time.Duration
+	Factor float64 // defaults to 2.
+}
+
+func (a *AdaptiveInterval) next(cur time.Duration, changed bool) time.Duration {
+	if changed || cur < a.Min {
+		return a.Min
+	}
+	f := a.Factor
+	if f <= 1 {
+		f = 2
+	}
+	d := time.Duration(float64(cur) * f)
+	if d > a.Max {
+		d = a.Max
+	}
+	return d
+}
+
+// PollStats describes the recent activity of the Listener.
+type PollStats struct {
+	// Interval is the time Run waits before the next poll.
+	Interval time.Duration `json:"interval_ns"`
+	Adaptive bool          `json:"adaptive"`
+	// Polls is the number of polls performed, and Changes the
+	// number of them that changed the stored sources.
+	Polls   int `json:"polls"`
+	Changes int `json:"changes"`
+	// NoChangeStreak is the number of consecutive polls that
+	// did not change anything.
+	NoChangeStreak int `json:"no_change_streak"`
+}
+
+// PollIntervalExporter is implemented by the metrics exporters that
+// collect the interval between polls.
+type PollIntervalExporter interface {
+	SetPollInterval(d time.Duration)
 }
 
 // NewListener creates a new Listener with the provided storage, using
-// as Provider the MergedProvider implementation.
+// as Provider the MergedProvider implementation, or a StaticProvider
+// if a sources file is configured.
 func NewListener(c Config) *Listener {
-	hooker := &Hooker{hooked: make(map[string]*hookErr)}
+	clock := c.Clock
+	if clock == nil {
+		clock = core.SystemClock
+	}
+	hooker := &Hooker{
+		hooked:    make(map[string][]*hookErr),
+		Clock:     clock,
+		Threshold: c.HookErrThreshold,
+		Window:    c.HookErrWindow,
+	}
 
-	var p Provider = &MergedProvider{
-		ControlInterface: func(ifi *Interface) {
-			ifi.OnDialErr = hooker.HandleDialErr
-			ifi.SetMetricsExporter(c.MetricsExporter)
-		},
+	control := func(ifi *Interface) {
+		ifi.OnDialErr = hooker.HandleDialErr
+		ifi.KeepAlive = c.KeepAlive
+		ifi.SetMetricsExporter(c.MetricsExporter)
+	}
+	var targets []string
+	if c.Check != nil {
+		targets = c.Check.Targets
+	}
+	var p Provider = &MergedProvider{ControlInterface: control, CheckTargets: targets}
+	if c.SourcesFile != "" {
+		p = &StaticProvider{Path: c.SourcesFile, ControlInterface: control}
 	}
 	if c.Provider != nil {
 		p = c.Provider
 	}
 
+	interval, timeout := c.PollInterval, c.PollTimeout
+	if interval <= 0 {
+		interval = PollInterval
+	}
+	if timeout <= 0 {
+		timeout = PollTimeout
+	}
+
 	return &Listener{
-		s:        c.Store,
-		h:        hooker,
-		Provider: p,
+		pollInterval: interval,
+		pollTimeout:  timeout,
+		s:            c.Store,
+		h:            hooker,
+		clock:        clock,
+		trigger:      make(chan struct{}, 1),
+		adaptive:     c.AdaptivePoll,
+		exp:          c.MetricsExporter,
+		checkConf:    c.Check,
+		Provider:     p,
 	}
 }
 
@@ -97,18 +237,33 @@ func (err *hookErr) Error() string {
 	return fmt.Sprintf("error %v produced by source %s while contacting %s using %s", err.err, err.ref, err.address, err.network)
 }
 
+// Hooker collects the dial errors produced by the sources. A source
+// is reported as faulty by HookErr only when it produced at least
+// Threshold errors within Window.
 type Hooker struct {
 	sync.Mutex
-	hooked map[string]*hookErr // list of hook errors mapped by source ID
+	hooked map[string][]*hookErr // recent hook errors mapped by source ID, oldest first.
+
+	// Clock defaults to core.SystemClock.
+	Clock core.Clock
+
+	// Threshold is the number of errors needed to report a source.
+	// Values lower than 1 are treated as 1, i.e. the first error is
+	// reported.
+	Threshold int
+	// Window is the time span over which errors are counted, older
+	// ones are discarded. If zero, errors are kept until consumed.
+	Window time.Duration
 }
 
 func (h *Hooker) HandleDialErr(ref, network, address string, err error) {
 	log.Debug.Printf("Listener: ErrHook called from %s (net: %s, addr: %s): %v", ref, network, address, err)
 
 	hookErr := &hookErr{
-		receivedAt: time.Now(),
+		receivedAt: h.now(),
 		ref:        ref,
 		network:    network,
+		address:    address,
 		err:        err,
 	}
 	h.Add(hookErr)
@@ -116,48 +271,197 @@ func (h *Hooker) HandleDialErr(ref, network, address string, err error) {
 
 func (h *Hooker) Add(err *hookErr) {
 	h.Lock()
+	defer h.Unlock()
+
 	if h.hooked == nil {
-		h.hooked = make(map[string]*hookErr)
+		h.hooked = make(map[string][]*hookErr)
+	}
+	// Only the most recent errors are needed to tell whether the
+	// threshold is crossed.
+	errs := append(h.hooked[err.ref], err)
+	if n := h.threshold(); len(errs) > n {
+		errs = append(errs[:0], errs[len(errs)-n:]...)
+	}
+	h.hooked[err.ref] = errs
+
+	// Also drop the errors of the sources that are no longer producing
+	// them.
+	for id := range h.hooked {
+		h.prune(id)
+	}
+}
+
+func (h *Hooker) threshold() int {
+	if h.Threshold < 1 {
+		return 1
+	}
+	return h.Threshold
+}
+
+// prune removes the errors of `id` that are out of the window. Must
+// be called with the lock held.
+func (h *Hooker) prune(id string) []*hookErr {
+	errs := h.hooked[id]
+	if h.Window > 0 {
+		deadline := h.now().Add(-h.Window)
+		i := 0
+		for i < len(errs) && !errs[i].receivedAt.After(deadline) {
+			i++
+		}
+		errs = errs[i:]
+	}
+	if len(errs) == 0 {
+		delete(h.hooked, id)
+		return nil
+	}
+	h.hooked[id] = errs
+	return errs
+}
+
+func (h *Hooker) now() time.Time {
+	if h.Clock == nil {
+		return core.SystemClock.Now()
+	}
+	return h.Clock.Now()
+}
+
+// Peek returns the most recent hook error collected for `id`, if any,
+// together with the number of errors within the window, without
+// consuming them.
+func (h *Hooker) Peek(id string) (error, int) {
+	if err, n := h.peek(id); err != nil {
+		return err, n
+	}
+	return nil, 0
+}
+
+func (h *Hooker) peek(id string) (*hookErr, int) {
+	h.Lock()
+	defer h.Unlock()
+	errs := h.prune(id)
+	if len(errs) == 0 {
+		return nil, 0
+	}
+	return errs[len(errs)-1], len(errs)
+}
+
+// IDs returns the identifiers of the sources that have an hook error.
+func (h *Hooker) IDs() []string {
+	h.Lock()
+	defer h.Unlock()
+	acc := make([]string, 0, len(h.hooked))
+	for id := range h.hooked {
+		if len(h.prune(id)) > 0 {
+			acc = append(acc, id)
+		}
 	}
-	h.hooked[err.ref] = err
-	h.Unlock()
+	return acc
 }
 
+// HookErr returns the most recent hook error of `id` if the threshold
+// is crossed, consuming the errors collected. Otherwise it returns nil
+// and the errors are kept, so that they count towards the threshold.
 func (h *Hooker) HookErr(id string) error {
 	h.Lock()
 	defer h.Unlock()
 
-	if err, ok := h.hooked[id]; ok && err != nil {
-		delete(h.hooked, id) // cleanup, the error must be handled now.
-		return err
+	errs := h.prune(id)
+	if len(errs) < h.threshold() {
+		return nil
 	}
-	return nil
+	delete(h.hooked, id) // cleanup, the error must be handled now.
+	return errs[len(errs)-1]
+}
+
+// Clear discards the hook errors collected for `id`.
+func (h *Hooker) Clear(id string) {
+	h.Lock()
+	defer h.Unlock()
+	delete(h.hooked, id)
 }
 
 // Run is a blocking function which keeps on calling Poll and waiting
-// PollInterval amount of time. This function will stop with an error
-// only in case of a context cancelation and in case that the Poll
-// function returns with a critical error.
+// the poll interval (or the adaptive interval, if configured), or
+// until a health reset requests a new check. This
+// function will stop with an error only in case of a context
+// cancelation and in case that the Poll function returns with a
+// critical error.
 func (l *Listener) Run(ctx context.Context) error {
 	for {
-		_ctx, cancel := context.WithTimeout(ctx, PollTimeout)
-		defer cancel()
-
-		if err := l.Poll(_ctx); err != nil {
+		_ctx, cancel := context.WithTimeout(ctx, l.pollTimeout)
+		changed, err := l.poll(_ctx)
+		cancel()
+		if err != nil {
 			// Just log the error
 			log.Error.Println(err)
 		}
 
+		// Use a timer that can be stopped, otherwise each wakeup
+		// would leave a pending one behind.
+		t := l.clock.NewTimer(l.next(changed))
 		select {
 		case <-ctx.Done():
 			// Exit in case of context cancelation.
+			t.Stop()
 			return ctx.Err()
-		case <-time.After(PollInterval):
+		case <-t.C():
 			// Wait before polling again.
+		case <-l.trigger:
+			// A reset requested an immediate poll.
+			t.Stop()
+			l.resetInterval()
 		}
 	}
 }
 
+// next records the outcome of a poll, returning how long to wait
+// before the next one. The wait is shortened when a stored source is
+// due for a check earlier.
+func (l *Listener) next(changed bool) time.Duration {
+	due := l.untilDue()
+
+	l.stats.Lock()
+	defer l.stats.Unlock()
+
+	st := &l.stats.PollStats
+	st.Polls++
+	if changed {
+		st.Changes++
+		st.NoChangeStreak = 0
+	} else {
+		st.NoChangeStreak++
+	}
+
+	if l.adaptive == nil {
+		st.Interval = l.pollInterval
+	} else {
+		st.Adaptive = true
+		st.Interval = l.adaptive.next(st.Interval, changed)
+	}
+	if exp, ok := l.exp.(PollIntervalExporter); ok {
+		exp.SetPollInterval(st.Interval)
+	}
+	if due > 0 && due < st.Interval {
+		return due
+	}
+	return st.Interval
+}
+
+// resetInterval makes the next interval computed start again from
+// the minimum.
+func (l *Listener) resetInterval() {
+	l.stats.Lock()
+	defer l.stats.Unlock()
+	l.stats.Interval = 0
+}
+
+// PollStats returns the statistics about the polls performed by Run.
+func (l *Listener) PollStats() PollStats {
+	l.stats.Lock()
+	defer l.stats.Unlock()
+	return l.stats.PollStats
+}
+
 // StoredSources returns the list of sources that are already inside
 // the store.
 func (l *Listener) StoredSources() []core.Source {
@@ -201,53 +505,121 @@ func Diff(old, cur []core.Source) (add []core.Source, remove []core.Source) {
 // new source, saving into the storage the sources that provide an active
 // internet connection and removing the ones that are no longer available.
 func (l *Listener) Poll(ctx context.Context) error {
+	_, err := l.poll(ctx)
+	return err
+}
+
+// poll implements Poll, reporting also whether the stored sources
+// changed.
+func (l *Listener) poll(ctx context.Context) (bool, error) {
 	// Fetch new & old data
 	cur, err := l.Provide(ctx)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	old := l.StoredSources()
+	pending := l.takePending()
 
 	// Find difference from old to cur.
 	add, remove := Diff(old, cur)
 
 	// Inspect the new ones, add them if they provide an internet connection.
-	for _, v := range add {
+	accepted := make([]core.Source, 0, len(add))
+	for i, err := range l.checkAll(ctx, add) {
+		v := add[i]
 		log.Debug.Printf("Poll: add %v?", v)
-		if err := l.Check(ctx, v, High); err != nil {
+		if err != nil {
 			log.Debug.Printf("Poll: unable to add source: %v", err)
+			l.emit(SourceCheckFailed, v.ID(), err.Error())
 			continue
 		}
 		// New source WITH active internet connection found!
 		log.Info.Printf("Listener: adding (%v) to storage.", v)
-		l.s.Put(v)
+		accepted = append(accepted, v)
 	}
 
 	// Remove what has to be removed without further investigation
 	for _, v := range remove {
 		log.Info.Printf("Listener: removing (%v) from storage.", v)
-		l.s.Del(v)
-		_ = l.h.HookErr(v.ID()) // also consume hook errors.
+		l.h.Clear(v.ID()) // also discard hook errors.
+		l.forget(v)
+	}
+	l.update(old, accepted, remove)
+	for _, v := range accepted {
+		l.emit(SourceAdded, v.ID(), "")
+	}
+	for _, v := range remove {
+		l.emit(SourceRemoved, v.ID(), "no longer provided")
 	}
 
-	// Eventually remove the sources that contain hook errors.
+	// Eventually remove the sources that contain hook errors, or that
+	// fail their periodic check.
 	old = l.StoredSources() // as the list has been updated before the last call.
 	acc := make([]core.Source, 0, len(old))
+	now := l.clock.Now()
 	for _, src := range old {
-		if err = l.h.HookErr(src.ID()); err != nil {
-			// This source has an hook error.
+		if err = l.h.HookErr(src.ID()); err != nil || pending[src.ID()] || l.isDue(src, now) {
+			// This source has an hook error, it has to be checked
+			// again after an health reset, or its check interval
+			// expired.
 			acc = append(acc, src)
 		}
 	}
-	for _, v := range acc {
-		// We collected a hook error. This does not mean that the source does
-		// not provide an internet connection.
-		if err := l.Check(ctx, v, High); err != nil {
-			log.Info.Printf("Listener: removing (%v) from storage after hook error.", v)
-			l.s.Del(v)
+	evict := make([]core.Source, 0, len(acc))
+	reasons := make([]string, 0, len(acc))
+	// We collected a hook error. This does not mean that the source does
+	// not provide an internet connection.
+	for i, err := range l.checkAll(ctx, acc) {
+		v := acc[i]
+		if err != nil {
+			log.Info.Printf("Listener: removing (%v) from storage after failed check.", v)
+			l.emit(SourceCheckFailed, v.ID(), err.Error())
+			evict = append(evict, v)
+			reasons = append(reasons, "check failed: "+err.Error())
+		}
+	}
+	if len(evict) > 0 {
+		l.s.Del(evict...)
+		for i, v := range evict {
+			l.emit(SourceRemoved, v.ID(), reasons[i])
+		}
+	}
+
+	return len(accepted) > 0 || len(remove) > 0 || len(evict) > 0, nil
+}
+
+// update applies to the store the changes computed from `old`, i.e.
+// the sources that were stored before the changes were computed.
+func (l *Listener) update(old, add, remove []core.Source) {
+	if len(add) == 0 && len(remove) == 0 {
+		return
+	}
+
+	r, ok := l.s.(Replacer)
+	if !ok {
+		// Apply each kind of change with a single call, the store
+		// handles each of them as one operation.
+		if len(add) > 0 {
+			l.s.Put(add...)
+		}
+		if len(remove) > 0 {
+			l.s.Del(remove...)
+		}
+		return
+	}
+
+	m := make(map[string]bool, len(remove))
+	for _, v := range remove {
+		m[v.ID()] = true
+	}
+	acc := make([]core.Source, 0, len(old)+len(add))
+	for _, v := range old {
+		if !m[v.ID()] {
+			acc = append(acc, v)
 		}
 	}
+	acc = append(acc, add...)
 
-	return nil
+	r.ReplaceAll(acc...)
 }
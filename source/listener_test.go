@@ -21,11 +21,13 @@ import (
 	"fmt"
 	"net"
 	"sort"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/booster-proj/booster/core"
 	"github.com/booster-proj/booster/source"
+	"github.com/booster-proj/booster/testutil"
 )
 
 type mock struct {
@@ -65,6 +67,57 @@ func TestHooker(t *testing.T) {
 	}
 }
 
+func TestHooker_threshold(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	h := &source.Hooker{Threshold: 3, Window: 50 * time.Millisecond, Clock: clock}
+	ref := "foo"
+	fail := func() { h.HandleDialErr(ref, "net", "addr", errors.New("some error")) }
+
+	fail()
+	fail()
+	if err := h.HookErr(ref); err != nil {
+		t.Fatalf("Wanted nil error below threshold for id %s, found %v", ref, err)
+	}
+	if err, n := h.Peek(ref); err == nil || n != 2 {
+		t.Fatalf("Unexpected peeked errors for id %s: %v, count %d", ref, err, n)
+	}
+	fail()
+	if err := h.HookErr(ref); err == nil {
+		t.Fatalf("Wanted hook error for id %s, found nil", ref)
+	}
+	if err := h.HookErr(ref); err != nil {
+		t.Fatalf("Wanted nil error after consuming for id %s, found %v", ref, err)
+	}
+	if err, n := h.Peek(ref); err != nil || n != 0 {
+		t.Fatalf("Unexpected peeked errors after consuming for id %s: %v, count %d", ref, err, n)
+	}
+
+	// Errors out of the window do not count.
+	fail()
+	fail()
+	clock.Advance(50 * time.Millisecond)
+	fail()
+	if err := h.HookErr(ref); err != nil {
+		t.Fatalf("Wanted nil error with expired errors for id %s, found %v", ref, err)
+	}
+	if _, n := h.Peek(ref); n != 1 {
+		t.Fatalf("Unexpected error count for id %s: wanted 1, found %d", ref, n)
+	}
+
+	// Errors still within the window count.
+	clock.Advance(40 * time.Millisecond)
+	fail()
+	if _, n := h.Peek(ref); n != 2 {
+		t.Fatalf("Unexpected error count for id %s: wanted 2, found %d", ref, n)
+	}
+
+	// Errors of silent sources are pruned as well.
+	clock.Advance(50 * time.Millisecond)
+	if ids := h.IDs(); len(ids) != 0 {
+		t.Fatalf("Unexpected sources with hook errors: %v", ids)
+	}
+}
+
 type storage struct {
 	data    []core.Source
 	putHook func(ss ...core.Source)
@@ -114,6 +167,19 @@ func (s *storage) Do(f func(core.Source)) {
 	}
 }
 
+// replacer is a storage that is also a source.Replacer.
+type replacer struct {
+	storage
+	replaceHook func(ss ...core.Source)
+}
+
+func (s *replacer) ReplaceAll(ss ...core.Source) {
+	s.data = ss
+	if f := s.replaceHook; f != nil {
+		f(ss...)
+	}
+}
+
 type mockProvider struct {
 	sources []*mock
 }
@@ -159,6 +225,50 @@ func TestRun_cancel(t *testing.T) {
 
 }
 
+// countingProvider notifies each Provide call.
+type countingProvider struct {
+	mockProvider
+	c chan struct{}
+}
+
+func (p *countingProvider) Provide(ctx context.Context) ([]core.Source, error) {
+	p.c <- struct{}{}
+	return p.mockProvider.Provide(ctx)
+}
+
+func TestRun_pollInterval(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	p := &countingProvider{c: make(chan struct{}, 1)}
+	l := source.NewListener(source.Config{Store: new(storage), Clock: clock})
+	l.Provider = p
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	go l.Run(ctx)
+
+	wait := func(i int) {
+		select {
+		case <-p.c:
+		case <-time.After(time.Second):
+			t.Fatalf("%d: Poll was not called", i)
+		}
+	}
+
+	wait(0) // first poll happens immediately.
+	for i := 1; i <= 3; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(source.PollInterval / 2)
+		select {
+		case <-p.c:
+			t.Fatalf("%d: Poll called before PollInterval elapsed", i)
+		default:
+		}
+
+		clock.Advance(source.PollInterval / 2)
+		wait(i)
+	}
+}
+
 func mocksFrom(s ...string) []core.Source {
 	ret := make([]core.Source, len(s))
 	for i, v := range s {
@@ -330,3 +440,407 @@ func TestPoll(t *testing.T) {
 		}
 	}
 }
+
+func TestPoll_replaceAll(t *testing.T) {
+	var calls int
+	s := &replacer{
+		replaceHook: func(ss ...core.Source) {
+			calls++
+		},
+	}
+	s.putHook = func(ss ...core.Source) {
+		t.Fatalf("Unexpected Put call with %v", ss)
+	}
+	s.delHook = func(ss ...core.Source) {
+		t.Fatalf("Unexpected Del call with %v", ss)
+	}
+
+	en0 := &mock{id: "en0", active: true}
+	en1 := &mock{id: "en1", active: true}
+	en2 := &mock{id: "en2", active: true}
+	p := &mockProvider{
+		sources: []*mock{en0, en1},
+	}
+	l := source.NewListener(source.Config{Store: s})
+	l.Provider = p
+
+	ctx := context.Background()
+	if err := l.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !sameContent(s.data, mocksFrom("en0", "en1")) {
+		t.Fatalf("Unexpected store content: wanted [en0 en1], found %v", s.data)
+	}
+
+	// Swap the provider composition: en0 goes away, en2 shows up.
+	p.sources = []*mock{en1, en2}
+	if err := l.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !sameContent(s.data, mocksFrom("en1", "en2")) {
+		t.Fatalf("Unexpected store content: wanted [en1 en2], found %v", s.data)
+	}
+
+	// Nothing changed, the store should not be touched.
+	if err := l.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Fatalf("Unexpected ReplaceAll calls: wanted 2, found %d", calls)
+	}
+}
+
+func TestPoll_batch(t *testing.T) {
+	var puts, dels [][]core.Source
+	s := &storage{
+		putHook: func(ss ...core.Source) {
+			puts = append(puts, ss)
+		},
+		delHook: func(ss ...core.Source) {
+			dels = append(dels, ss)
+		},
+	}
+	p := &mockProvider{}
+	for _, v := range []string{"en0", "en1", "en2", "en3"} {
+		p.sources = append(p.sources, &mock{id: v, active: true})
+	}
+	l := source.NewListener(source.Config{Store: s})
+	l.Provider = p
+
+	// Docking station connected: 4 new sources at once.
+	ctx := context.Background()
+	if err := l.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(puts) != 1 || len(puts[0]) != 4 {
+		t.Fatalf("Unexpected Put calls: wanted one call with 4 sources, found %v", puts)
+	}
+	if len(dels) != 0 {
+		t.Fatalf("Unexpected Del calls: %v", dels)
+	}
+
+	// ...and disconnected.
+	p.sources = nil
+	if err := l.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(dels) != 1 || len(dels[0]) != 4 {
+		t.Fatalf("Unexpected Del calls: wanted one call with 4 sources, found %v", dels)
+	}
+	if len(puts) != 1 {
+		t.Fatalf("Unexpected Put calls: %v", puts)
+	}
+}
+
+func TestResetHealth(t *testing.T) {
+	s := new(storage)
+	en0 := &mock{id: "en0", active: true}
+	en1 := &mock{id: "en1", active: true}
+	p := &mockProvider{
+		sources: []*mock{en0, en1},
+	}
+	l := source.NewListener(source.Config{Store: s})
+	l.Provider = p
+
+	ctx := context.Background()
+	if err := l.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.ResetHealth("foo"); err == nil {
+		t.Fatalf("Expected error resetting unknown source")
+	}
+	h, ok := l.Health("en0")
+	if !ok {
+		t.Fatalf("Health of en0 not found")
+	}
+	if !h.Stored || h.LastCheck == nil || h.LastCheck.Err != "" || h.RecheckPending {
+		t.Fatalf("Unexpected health of en0: %+v", h)
+	}
+
+	// The reset must not make en0 healthy: the check fails
+	// and the source is removed.
+	en0.active = false
+	if err := l.ResetHealth("en0"); err != nil {
+		t.Fatal(err)
+	}
+	if h, _ = l.Health("en0"); !h.RecheckPending {
+		t.Fatalf("Expected pending recheck for en0: %+v", h)
+	}
+	if err := l.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !sameContent(s.data, mocksFrom("en1")) {
+		t.Fatalf("Unexpected store content: wanted [en1], found %v", s.data)
+	}
+	h, ok = l.Health("en0")
+	if !ok || h.Stored || h.RecheckPending || h.LastCheck == nil || h.LastCheck.Err == "" {
+		t.Fatalf("Unexpected health of en0: %+v", h)
+	}
+
+	// en1 passes the check and stays.
+	if ids := l.ResetAllHealth(); len(ids) != 2 {
+		t.Fatalf("Unexpected reset sources: %v", ids)
+	}
+	if err := l.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !sameContent(s.data, mocksFrom("en1")) {
+		t.Fatalf("Unexpected store content: wanted [en1], found %v", s.data)
+	}
+}
+
+func TestResetHealth_wakeup(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	p := &countingProvider{c: make(chan struct{}, 1)}
+	p.sources = []*mock{{id: "en0", active: true}}
+	l := source.NewListener(source.Config{Store: new(storage), Clock: clock})
+	l.Provider = p
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	go l.Run(ctx)
+
+	wait := func() {
+		select {
+		case <-p.c:
+		case <-time.After(time.Second):
+			t.Fatalf("Provide was not called")
+		}
+	}
+	wait()
+	clock.BlockUntil(1)
+
+	// No need to advance the clock, the reset polls immediately.
+	for i := 0; i < 3; i++ {
+		if err := l.ResetHealth("en0"); err != nil {
+			t.Fatal(err)
+		}
+		wait()
+		clock.BlockUntil(1)
+	}
+
+	// The timers interrupted by the resets are not left behind.
+	if n := clock.Waiters(); n != 1 {
+		t.Fatalf("Unexpected number of clock waiters: wanted 1, found %d", n)
+	}
+}
+
+func TestRun_adaptivePoll(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	p := &countingProvider{c: make(chan struct{}, 1)}
+	l := source.NewListener(source.Config{
+		Store: new(storage),
+		Clock: clock,
+		AdaptivePoll: &source.AdaptiveInterval{
+			Min: time.Second,
+			Max: 4 * time.Second,
+		},
+	})
+	l.Provider = p
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	go l.Run(ctx)
+
+	// poll waits for a poll to be performed, and returns the interval
+	// Run waits before the next one.
+	poll := func() time.Duration {
+		select {
+		case <-p.c:
+		case <-time.After(time.Second):
+			t.Fatalf("Provide was not called")
+		}
+		clock.BlockUntil(1)
+		return l.PollStats().Interval
+	}
+
+	// Nothing changes: the interval grows up to the maximum.
+	intervals := []time.Duration{1, 2, 4, 4}
+	for i, v := range intervals {
+		if d := poll(); d != v*time.Second {
+			t.Fatalf("%d: Unexpected interval: wanted %v, found %v", i, v*time.Second, d)
+		}
+		if i == len(intervals)-1 {
+			// Run is waiting on the clock: safe to change
+			// the provider.
+			p.sources = []*mock{{id: "en0", active: true}}
+		}
+		clock.Advance(v * time.Second)
+	}
+
+	// A new source resets it.
+	if d := poll(); d != time.Second {
+		t.Fatalf("Unexpected interval after change: wanted %v, found %v", time.Second, d)
+	}
+	if st := l.PollStats(); st.Polls != 5 || st.Changes != 1 || st.NoChangeStreak != 0 {
+		t.Fatalf("Unexpected poll stats: %+v", st)
+	}
+}
+
+func TestPoll_checkConfidence(t *testing.T) {
+	s := new(storage)
+	l := source.NewListener(source.Config{
+		Store: s,
+		Provider: &mockProvider{sources: []*mock{
+			{id: "en0", active: false},
+			{id: "lte0", active: false},
+		}},
+		Check: &source.CheckConfig{
+			Confidence: source.High,
+			Sources:    map[string]source.Confidence{"lte0": source.Low},
+		},
+	})
+
+	if err := l.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.data) != 1 || s.data[0].ID() != "lte0" {
+		t.Fatalf("Unexpected stored sources: wanted [lte0], found %v", s.data)
+	}
+	for id, want := range map[string]source.Confidence{"en0": source.High, "lte0": source.Low} {
+		h, _ := l.Health(id)
+		if h.LastCheck == nil || h.LastCheck.Confidence != want {
+			t.Fatalf("Unexpected last check of %s: wanted confidence %v, found %+v", id, want, h.LastCheck)
+		}
+	}
+}
+
+// hinted is a mock that asks to be checked periodically.
+type hinted struct {
+	mock
+	every time.Duration
+}
+
+func (s *hinted) CheckIntervalHint() time.Duration {
+	return s.every
+}
+
+// checkCountProvider provides a fixed list of sources, counting the
+// checks performed on each of them.
+type checkCountProvider struct {
+	sources []core.Source
+
+	sync.Mutex
+	checks map[string]int
+	// running and max are the number of checks running, and the
+	// maximum number of them that ran at the same time.
+	running, max int
+}
+
+func (p *checkCountProvider) Provide(ctx context.Context) ([]core.Source, error) {
+	return p.sources, nil
+}
+
+func (p *checkCountProvider) Check(ctx context.Context, src core.Source, level source.Confidence) error {
+	p.Lock()
+	p.checks[src.ID()]++
+	p.running++
+	if p.running > p.max {
+		p.max = p.running
+	}
+	p.Unlock()
+
+	// Give the other checks the chance to run concurrently.
+	time.Sleep(time.Millisecond * 10)
+
+	p.Lock()
+	p.running--
+	p.Unlock()
+
+	_, err := src.DialContext(ctx, "net", "addr")
+	return err
+}
+
+func TestPoll_checkInterval(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	eth := &hinted{mock: mock{id: "eth0", active: true}, every: 5 * time.Second}
+	lte := &hinted{mock: mock{id: "lte0", active: true}, every: 2 * time.Second}
+	plain := &mock{id: "en0", active: true}
+	p := &checkCountProvider{
+		sources: []core.Source{eth, lte, plain},
+		checks:  make(map[string]int),
+	}
+	l := source.NewListener(source.Config{
+		Store:        new(storage),
+		Provider:     p,
+		Clock:        clock,
+		PollInterval: time.Second,
+	})
+
+	for i := 0; i <= 10; i++ {
+		if err := l.Poll(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Second)
+	}
+
+	// One check when the source is added, then one each interval.
+	for id, want := range map[string]int{"eth0": 3, "lte0": 6, "en0": 1} {
+		if n := p.checks[id]; n != want {
+			t.Fatalf("Unexpected number of checks for %s: wanted %d, found %d", id, want, n)
+		}
+	}
+}
+
+func TestPoll_revalidate(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	srcs := make([]core.Source, 10)
+	for i := range srcs {
+		srcs[i] = &mock{id: fmt.Sprintf("en%d", i), active: true}
+	}
+	p := &checkCountProvider{sources: srcs, checks: make(map[string]int)}
+	s := new(storage)
+	l := source.NewListener(source.Config{
+		Store:    s,
+		Provider: p,
+		Clock:    clock,
+		Check: &source.CheckConfig{
+			Interval:    10 * time.Second,
+			Intervals:   map[string]time.Duration{"en1": 0},
+			Concurrency: 2,
+		},
+	})
+
+	if err := l.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.data) != len(srcs) {
+		t.Fatalf("Unexpected stored sources: %v", s.data)
+	}
+	if p.max != 2 {
+		t.Fatalf("Unexpected number of concurrent checks: wanted 2, found %d", p.max)
+	}
+
+	// Sources losing their connection are not noticed before the
+	// interval expires.
+	srcs[0].(*mock).active = false
+	srcs[1].(*mock).active = false
+	clock.Advance(5 * time.Second)
+	if err := l.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.data) != len(srcs) {
+		t.Fatalf("Unexpected stored sources before the interval expired: %v", s.data)
+	}
+
+	// en1 opted out of the periodic checks.
+	clock.Advance(5 * time.Second)
+	if err := l.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.data) != len(srcs)-1 {
+		t.Fatalf("Unexpected stored sources after the interval expired: %v", s.data)
+	}
+	for _, v := range s.data {
+		if v.ID() == "en0" {
+			t.Fatalf("Source en0 should have been removed")
+		}
+	}
+	if p.checks["en1"] != 1 || p.checks["en2"] != 2 {
+		t.Fatalf("Unexpected number of checks: %v", p.checks)
+	}
+	if p.max != 2 {
+		t.Fatalf("Unexpected number of concurrent checks: wanted 2, found %d", p.max)
+	}
+}
@@ -25,6 +25,9 @@ import (
 )
 
 type Local struct {
+	// Targets used to check the connection of the interfaces.
+	// Defaults to DefaultCheckTargets.
+	Targets []string
 }
 
 func (l *Local) Provide(ctx context.Context, level Confidence) ([]*Interface, error) {
@@ -45,8 +48,14 @@ func (l *Local) Provide(ctx context.Context, level Confidence) ([]*Interface, er
 
 func (l *Local) Check(ctx context.Context, ifi *Interface, level Confidence) error {
 	checks := []check{hasHardwareAddr, hasIP}
-	if level == High {
-		checks = append(checks, hasNetworkConnRetry)
+	if level > Low {
+		targets := l.Targets
+		if len(targets) == 0 {
+			targets = DefaultCheckTargets
+		}
+		checks = append(checks, func(ctx context.Context, ifi *Interface) error {
+			return checkConnRetry(ctx, ifi, targets, level)
+		})
 	}
 
 	return pipeline(ctx, ifi, checks...)
@@ -110,26 +119,14 @@ func hasIP(ctx context.Context, ifi *Interface) error {
 	return nil
 }
 
-func hasNetworkConn(ctx context.Context, ifi *Interface) error {
-	ctx, cancel := context.WithTimeout(ctx, time.Millisecond*500)
-	defer cancel()
-
-	conn, err := ifi.DialContext(ctx, "tcp", "google.com:80")
-	if err != nil {
-		return fmt.Errorf("unable to dial connection using interface %s: %v", ifi.ID(), err)
-	}
-	conn.Close()
-	return nil
-}
-
-func hasNetworkConnRetry(ctx context.Context, ifi *Interface) error {
+func checkConnRetry(ctx context.Context, ifi *Interface, targets []string, level Confidence) error {
 	for i := 0; i < 3; i++ {
 		if i == 2 {
 			// last item
-			return hasNetworkConn(ctx, ifi)
+			return CheckConn(ctx, ifi, targets, level)
 		}
 
-		if err := hasNetworkConn(ctx, ifi); err == nil {
+		if err := CheckConn(ctx, ifi, targets, level); err == nil {
 			return nil
 		}
 
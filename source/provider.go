@@ -18,17 +18,48 @@ package source
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/booster-proj/booster/core"
 )
 
+// Confidence describes how thoroughly the connection of a source is
+// checked. Low only checks that the interface has an address, Medium
+// connects to the check targets and High performs an HTTP request
+// against them.
 type Confidence int
 
 const (
 	Low Confidence = iota
+	Medium
 	High
 )
 
+var confidenceNames = []string{"low", "medium", "high"}
+
+func (c Confidence) String() string {
+	if c < Low || c > High {
+		return fmt.Sprintf("Confidence(%d)", int(c))
+	}
+	return confidenceNames[c]
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (c Confidence) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// ParseConfidence returns the confidence level named `s`, i.e.
+// one of "low", "medium" or "high".
+func ParseConfidence(s string) (Confidence, error) {
+	for i, v := range confidenceNames {
+		if strings.EqualFold(s, v) {
+			return Confidence(i), nil
+		}
+	}
+	return Low, fmt.Errorf("provider: unknown confidence level %q", s)
+}
+
 // Provider is a provider implementation which acts as a wrapper
 // around many provider implementations.
 type MergedProvider struct {
@@ -37,17 +68,24 @@ type MergedProvider struct {
 	// it is hidden inside a core.Source.
 	ControlInterface func(ifi *Interface)
 
+	// CheckTargets are the targets used to check the connection of
+	// the interfaces, see CheckConfig. Defaults to DefaultCheckTargets.
+	CheckTargets []string
+
 	local *Local
 }
 
-// Provide returns the list of sources returned by each provider owned
-// by merged. Currently only a local provider is queried.
-func (p *MergedProvider) Provide(ctx context.Context) ([]core.Source, error) {
+func (p *MergedProvider) getLocal() *Local {
 	if p.local == nil {
-		p.local = new(Local)
+		p.local = &Local{Targets: p.CheckTargets}
 	}
+	return p.local
+}
 
-	interfaces, err := p.local.Provide(ctx, Low)
+// Provide returns the list of sources returned by each provider owned
+// by merged. Currently only a local provider is queried.
+func (p *MergedProvider) Provide(ctx context.Context) ([]core.Source, error) {
+	interfaces, err := p.getLocal().Provide(ctx, Low)
 	if err != nil {
 		return []core.Source{}, err
 	}
@@ -64,7 +102,7 @@ func (p *MergedProvider) Provide(ctx context.Context) ([]core.Source, error) {
 
 func (p *MergedProvider) Check(ctx context.Context, src core.Source, level Confidence) error {
 	if ifi, ok := src.(*Interface); ok {
-		return p.local.Check(ctx, ifi, level)
+		return p.getLocal().Check(ctx, ifi, level)
 	}
 	return fmt.Errorf("provider: unable to find suitable checks for source %s", src.ID())
 }
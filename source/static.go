@@ -0,0 +1,164 @@
+// Copyright © 2019 KIM KeepInMind GmbH/srl
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/booster-proj/booster/core"
+)
+
+// StaticSource describes a source declared in a StaticProvider file.
+type StaticSource struct {
+	// Name is the source identifier.
+	Name string `json:"name"`
+	// Address is the local address the connections of the source
+	// are bound to.
+	Address string `json:"address"`
+	// Sources with lower Priority values are provided first.
+	Priority int `json:"priority"`
+	// Check, if set, is the "host:port" address dialed through the
+	// source when its connection is checked with Medium or High
+	// confidence. Otherwise the source is always considered active.
+	Check string `json:"check,omitempty"`
+	// CheckInterval, if set, is how often the source is checked
+	// again while stored, as accepted by time.ParseDuration.
+	CheckInterval string `json:"check_interval,omitempty"`
+}
+
+// StaticProvider is a Provider implementation that provides the
+// sources declared in a JSON file, instead of looking for them between
+// the network interfaces. The file contains an object with a "sources"
+// list of StaticSource values, and it is read again on each call to
+// Provide, so changes are picked up with the next Poll.
+// Note that the Listener identifies sources by name: renaming a source
+// is needed to make it apply a new address.
+type StaticProvider struct {
+	// Path of the JSON file.
+	Path string
+
+	// ControlInterface has the same meaning of the
+	// MergedProvider's one.
+	ControlInterface func(ifi *Interface)
+
+	mux    sync.Mutex
+	checks map[string]string // check addresses mapped by source ID.
+}
+
+// Load reads and validates the sources declared in the file.
+func (p *StaticProvider) Load() ([]StaticSource, error) {
+	b, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		return nil, fmt.Errorf("static provider: %v", err)
+	}
+
+	var f struct {
+		Sources []StaticSource `json:"sources"`
+	}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("static provider: unable to parse %s: %v", p.Path, err)
+	}
+
+	seen := make(map[string]bool, len(f.Sources))
+	for i, v := range f.Sources {
+		if v.Name == "" {
+			return nil, fmt.Errorf("static provider: source %d has no name", i)
+		}
+		if seen[v.Name] {
+			return nil, fmt.Errorf("static provider: source %s is declared more than once", v.Name)
+		}
+		seen[v.Name] = true
+		if net.ParseIP(v.Address) == nil {
+			return nil, fmt.Errorf("static provider: source %s: invalid address %q", v.Name, v.Address)
+		}
+		if v.Check != "" {
+			if _, _, err := net.SplitHostPort(v.Check); err != nil {
+				return nil, fmt.Errorf("static provider: source %s: invalid check address: %v", v.Name, err)
+			}
+		}
+		if v.CheckInterval != "" {
+			if _, err := time.ParseDuration(v.CheckInterval); err != nil {
+				return nil, fmt.Errorf("static provider: source %s: invalid check interval: %v", v.Name, err)
+			}
+		}
+	}
+
+	sort.SliceStable(f.Sources, func(i, j int) bool {
+		return f.Sources[i].Priority < f.Sources[j].Priority
+	})
+	return f.Sources, nil
+}
+
+// Provide implements Provider, returning the sources declared in the
+// file sorted by priority. If the file cannot be loaded an error is
+// returned, and the Listener keeps the sources it already stored.
+func (p *StaticProvider) Provide(ctx context.Context) ([]core.Source, error) {
+	decl, err := p.Load()
+	if err != nil {
+		return []core.Source{}, err
+	}
+
+	checks := make(map[string]string, len(decl))
+	sources := make([]core.Source, 0, len(decl))
+	for _, v := range decl {
+		ifi := &Interface{
+			ifi:       net.Interface{Name: v.Name},
+			LocalAddr: net.ParseIP(v.Address),
+		}
+		if v.CheckInterval != "" {
+			ifi.CheckInterval, _ = time.ParseDuration(v.CheckInterval) // validated by Load.
+		}
+		if f := p.ControlInterface; f != nil {
+			f(ifi)
+		}
+		checks[v.Name] = v.Check
+		sources = append(sources, ifi)
+	}
+	p.mux.Lock()
+	p.checks = checks
+	p.mux.Unlock()
+
+	return sources, nil
+}
+
+// Check implements Provider. With Medium or High confidence, and if the
+// source declares a check address, a connection is dialed through the
+// source. In any other case the source is considered active.
+func (p *StaticProvider) Check(ctx context.Context, src core.Source, level Confidence) error {
+	p.mux.Lock()
+	addr := p.checks[src.ID()]
+	p.mux.Unlock()
+	if level < Medium || addr == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	conn, err := src.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("static provider: unable to dial %s using source %s: %v", addr, src.ID(), err)
+	}
+	conn.Close()
+	return nil
+}
@@ -0,0 +1,202 @@
+// Copyright © 2019 KIM KeepInMind GmbH/srl
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package source_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/booster-proj/booster/source"
+)
+
+func writeSources(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStaticProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "booster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := &source.StaticProvider{Path: filepath.Join(dir, "sources.json")}
+	if _, err := p.Provide(context.Background()); err == nil {
+		t.Fatalf("Expected error with missing file")
+	}
+
+	writeSources(t, p.Path, `{"sources": [
+		{"name": "lte0", "address": "10.0.0.2", "priority": 2},
+		{"name": "wan0", "address": "192.168.1.2", "priority": 1}
+	]}`)
+	assertIDs := func(want ...string) {
+		srcs, err := p.Provide(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(srcs) != len(want) {
+			t.Fatalf("Unexpected sources: wanted %v, found %v", want, srcs)
+		}
+		for i, v := range srcs {
+			if v.ID() != want[i] {
+				t.Fatalf("%d: Unexpected source: wanted %v, found %v", i, want[i], v.ID())
+			}
+		}
+	}
+	assertIDs("wan0", "lte0")
+
+	// Changes are picked up by the next call.
+	writeSources(t, p.Path, `{"sources": [{"name": "lte0", "address": "10.0.0.2"}]}`)
+	assertIDs("lte0")
+
+	for i, v := range []string{
+		`{"sources": [{"name": "", "address": "10.0.0.2"}]}`,
+		`{"sources": [{"name": "lte0", "address": "lte"}]}`,
+		`{"sources": [{"name": "lte0", "address": "10.0.0.2"}, {"name": "lte0", "address": "10.0.0.3"}]}`,
+		`{"sources": [{"name": "lte0", "address": "10.0.0.2", "check": "example.com"}]}`,
+		`{"sources": [{"name": "lte0", "address": "10.0.0.2", "check_interval": "often"}]}`,
+		`{"sources": `,
+	} {
+		writeSources(t, p.Path, v)
+		if _, err := p.Provide(context.Background()); err == nil {
+			t.Fatalf("%d: Expected error with invalid file %s", i, v)
+		}
+	}
+}
+
+func TestStaticProvider_check(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	// Find an address nobody is listening on.
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	dir, err := ioutil.TempDir("", "booster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := &source.StaticProvider{Path: filepath.Join(dir, "sources.json")}
+	writeSources(t, p.Path, `{"sources": [
+		{"name": "up", "address": "127.0.0.1", "check": "`+ln.Addr().String()+`"},
+		{"name": "down", "address": "127.0.0.1", "check": "`+closed.Addr().String()+`"},
+		{"name": "unchecked", "address": "127.0.0.1"}
+	]}`)
+	srcs, err := p.Provide(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		level source.Confidence
+		ok    []bool // one for each source, in order.
+	}{
+		{level: source.High, ok: []bool{true, false, true}},
+		{level: source.Low, ok: []bool{true, true, true}},
+	}
+	for _, v := range tt {
+		for i, src := range srcs {
+			err := p.Check(context.Background(), src, v.level)
+			if ok := err == nil; ok != v.ok[i] {
+				t.Fatalf("Unexpected check result for %v with confidence %d: %v", src.ID(), v.level, err)
+			}
+		}
+	}
+}
+
+// latencyExporter collects the check latencies.
+type latencyExporter struct {
+	latency map[string]time.Duration
+}
+
+func (e *latencyExporter) SendDataFlow(map[string]string, *source.DataFlow) {}
+func (e *latencyExporter) CountOpenConn(map[string]string, int)             {}
+func (e *latencyExporter) AddLatency(map[string]string, time.Duration)      {}
+func (e *latencyExporter) CountPort(map[string]string, int)                 {}
+
+func (e *latencyExporter) SetCheckLatency(labels map[string]string, d time.Duration) {
+	e.latency[labels["source"]] = d
+}
+
+func TestPoll_checkLatency(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	dir, err := ioutil.TempDir("", "booster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "sources.json")
+	writeSources(t, path, `{"sources": [{"name": "lo", "address": "127.0.0.1", "check": "`+ln.Addr().String()+`"}]}`)
+	s := new(storage)
+	exp := &latencyExporter{latency: make(map[string]time.Duration)}
+	l := source.NewListener(source.Config{Store: s, SourcesFile: path, MetricsExporter: exp})
+	if err := l.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.data) != 1 {
+		t.Fatalf("Unexpected stored sources: %v", s.data)
+	}
+	at, d := s.data[0].(*source.Interface).LastCheck()
+	if at.IsZero() || d <= 0 {
+		t.Fatalf("Unexpected last check: at %v, latency %v", at, d)
+	}
+	if exp.latency["lo"] != d {
+		t.Fatalf("Unexpected exported latency: wanted %v, found %v", d, exp.latency["lo"])
+	}
+	if h, _ := l.Health("lo"); h.LastCheck == nil || h.LastCheck.Latency != d {
+		t.Fatalf("Unexpected health check result: %+v", h.LastCheck)
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright © 2019 KIM KeepInMind GmbH/srl
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package store
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/booster-proj/booster/core"
+	"upspin.io/log"
+)
+
+// ChurnError is returned when a policy mutation is refused because its
+// issuer is cooling down after changing policies too often.
+type ChurnError struct {
+	Issuer string
+	// Until is the end of the cool-down, according to the clock of
+	// the limiter that refused the mutation.
+	Until time.Time
+	// RetryAfter is the time left before Until when the mutation
+	// was refused.
+	RetryAfter time.Duration
+	Reason     string
+}
+
+func (err *ChurnError) Error() string {
+	return fmt.Sprintf("source store: policy churn: issuer %q is cooling down until %v: %s", err.Issuer, err.Until.Format(time.RFC3339), err.Reason)
+}
+
+// ChurnRecord describes the recent policy mutations of an issuer.
+type ChurnRecord struct {
+	Issuer string `json:"issuer"`
+	// Mutations is the number of policies added or removed within
+	// the limiter's window.
+	Mutations int `json:"mutations"`
+	// CoolDownUntil is set when the issuer is not allowed to change
+	// policies.
+	CoolDownUntil *time.Time `json:"cool_down_until,omitempty"`
+	Reason        string     `json:"reason,omitempty"`
+}
+
+// ChurnLimiter keeps track of how often each issuer changes the policies,
+// and puts in cool-down the ones that do it too often, or that keep on
+// adding and removing the same policy.
+type ChurnLimiter struct {
+	// Window is the period of time over which mutations are counted.
+	Window time.Duration
+	// Soft is the number of mutations within Window after which a
+	// warning is logged. Defaults to half of Hard.
+	Soft int
+	// Hard is the number of mutations within Window after which the
+	// issuer is put in cool-down.
+	Hard int
+	// Oscillations is the number of times the same policy can be added
+	// within Window before the issuer is put in cool-down. Defaults
+	// to 5.
+	Oscillations int
+	// CoolDown is how long an issuer is not allowed to change policies.
+	CoolDown time.Duration
+	// Clock defaults to core.SystemClock.
+	Clock core.Clock
+
+	mux     sync.Mutex
+	issuers map[string]*churn
+}
+
+type churn struct {
+	mutations []time.Time
+	adds      map[string][]time.Time // indexed by policy identifier.
+	until     time.Time
+	reason    string
+}
+
+func (l *ChurnLimiter) now() time.Time {
+	if l.Clock == nil {
+		return core.SystemClock.Now()
+	}
+	return l.Clock.Now()
+}
+
+// Allow returns a *ChurnError if `issuer` is cooling down.
+func (l *ChurnLimiter) Allow(issuer string) error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	c, ok := l.issuers[issuer]
+	if !ok {
+		return nil
+	}
+	if now := l.now(); now.Before(c.until) {
+		return &ChurnError{Issuer: issuer, Until: c.until, RetryAfter: c.until.Sub(now), Reason: c.reason}
+	}
+	return nil
+}
+
+// Record accounts the policies `added` and `removed` by `issuer`. The
+// mutations performed while the issuer is cooling down are not accounted.
+func (l *ChurnLimiter) Record(issuer string, added, removed []string) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if l.issuers == nil {
+		l.issuers = make(map[string]*churn)
+	}
+	c, ok := l.issuers[issuer]
+	if !ok {
+		c = &churn{adds: make(map[string][]time.Time)}
+		l.issuers[issuer] = c
+	}
+
+	now := l.now()
+	if now.Before(c.until) {
+		// Only deletions are allowed while cooling down, they must
+		// not extend the penalty.
+		return
+	}
+	from := now.Add(-l.Window)
+	c.mutations = after(c.mutations, from)
+	for range added {
+		c.mutations = append(c.mutations, now)
+	}
+	for range removed {
+		c.mutations = append(c.mutations, now)
+	}
+
+	oscillating := ""
+	for _, id := range added {
+		c.adds[id] = append(after(c.adds[id], from), now)
+		if len(c.adds[id]) >= l.oscillations() {
+			oscillating = id
+		}
+	}
+	for id, v := range c.adds {
+		if v = after(v, from); len(v) == 0 {
+			delete(c.adds, id)
+		} else {
+			c.adds[id] = v
+		}
+	}
+
+	n := len(c.mutations)
+	switch {
+	case l.Hard > 0 && n >= l.Hard:
+		c.reason = fmt.Sprintf("%d policy mutations within %v", n, l.Window)
+	case oscillating != "":
+		c.reason = fmt.Sprintf("policy %s added %d times within %v", oscillating, len(c.adds[oscillating]), l.Window)
+	default:
+		if soft := l.soft(); soft > 0 && n >= soft {
+			log.Error.Printf("ChurnLimiter: issuer %q performed %d policy mutations within %v", issuer, n, l.Window)
+		}
+		return
+	}
+
+	c.until = now.Add(l.CoolDown)
+	c.mutations = nil
+	c.adds = make(map[string][]time.Time)
+	log.Error.Printf("ChurnLimiter: issuer %q cooling down until %v: %s", issuer, c.until, c.reason)
+}
+
+// Snapshot returns the state of each issuer that changed policies
+// within the window, or that is cooling down, sorted by issuer.
+func (l *ChurnLimiter) Snapshot() []ChurnRecord {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	now := l.now()
+	from := now.Add(-l.Window)
+	acc := make([]ChurnRecord, 0, len(l.issuers))
+	for issuer, c := range l.issuers {
+		c.mutations = after(c.mutations, from)
+		cooling := now.Before(c.until)
+		if len(c.mutations) == 0 && !cooling {
+			// Nothing left to remember about this issuer.
+			delete(l.issuers, issuer)
+			continue
+		}
+
+		r := ChurnRecord{Issuer: issuer, Mutations: len(c.mutations)}
+		if cooling {
+			until := c.until
+			r.CoolDownUntil = &until
+			r.Reason = c.reason
+		}
+		acc = append(acc, r)
+	}
+	sort.Slice(acc, func(i, j int) bool { return acc[i].Issuer < acc[j].Issuer })
+	return acc
+}
+
+func (l *ChurnLimiter) soft() int {
+	if l.Soft > 0 {
+		return l.Soft
+	}
+	return l.Hard / 2
+}
+
+func (l *ChurnLimiter) oscillations() int {
+	if l.Oscillations > 0 {
+		return l.Oscillations
+	}
+	return 5
+}
+
+// after returns the suffix of the sorted list `tt` that contains only
+// the times after `t`.
+func after(tt []time.Time, t time.Time) []time.Time {
+	i := sort.Search(len(tt), func(i int) bool { return tt[i].After(t) })
+	return tt[i:]
+}
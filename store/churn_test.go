@@ -0,0 +1,161 @@
+// Copyright © 2019 KIM KeepInMind GmbH/srl
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package store_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/booster-proj/booster/core"
+	"github.com/booster-proj/booster/store"
+	"github.com/booster-proj/booster/testutil"
+)
+
+func TestChurnLimiter_storm(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	s := store.New(&storage{data: []core.Source{}})
+	s.SetChurnLimiter(&store.ChurnLimiter{
+		Window:       time.Minute,
+		Hard:         10,
+		Oscillations: 100,
+		CoolDown:     5 * time.Minute,
+		Clock:        clock,
+	})
+
+	// A script blocking and unblocking sources in a loop.
+	var err error
+	var i int
+	for i = 0; i < 20 && err == nil; i++ {
+		p := store.NewBlockPolicy("script", string('a'+rune(i)))
+		if err = s.AppendPolicy(p); err != nil {
+			break
+		}
+		err = s.DelPolicy("script", p.ID())
+	}
+	if _, ok := err.(*store.ChurnError); !ok {
+		t.Fatalf("Unexpected error: wanted *store.ChurnError, found %v", err)
+	}
+	if i != 5 {
+		t.Fatalf("Unexpected number of iterations before cool-down: wanted 5, found %d", i)
+	}
+
+	// Other issuers are not affected.
+	if err = s.AppendPolicy(store.NewBlockPolicy("user", "foo")); err != nil {
+		t.Fatal(err)
+	}
+
+	records := s.GetChurnSnapshot()
+	if len(records) != 2 || records[0].Issuer != "script" || records[0].CoolDownUntil == nil {
+		t.Fatalf("Unexpected churn snapshot: %+v", records)
+	}
+
+	clock.Advance(5 * time.Minute)
+	if err = s.AppendPolicy(store.NewBlockPolicy("script", "bar")); err != nil {
+		t.Fatalf("Issuer still cooling down: %v", err)
+	}
+}
+
+func TestChurnLimiter_oscillation(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	l := &store.ChurnLimiter{
+		Window:       time.Minute,
+		Hard:         1000,
+		Oscillations: 3,
+		CoolDown:     time.Minute,
+		Clock:        clock,
+	}
+
+	for i := 0; i < 2; i++ {
+		l.Record("script", []string{"block_foo"}, nil)
+		l.Record("script", nil, []string{"block_foo"})
+		clock.Advance(time.Second)
+	}
+	if err := l.Allow("script"); err != nil {
+		t.Fatalf("Unexpected cool-down: %v", err)
+	}
+
+	// Policies added once each are not an oscillation.
+	l.Record("other", []string{"block_foo", "block_bar", "block_baz"}, nil)
+	if err := l.Allow("other"); err != nil {
+		t.Fatalf("Unexpected cool-down: %v", err)
+	}
+
+	l.Record("script", []string{"block_foo"}, nil)
+	if err := l.Allow("script"); err == nil {
+		t.Fatalf("Expected cool-down after oscillation")
+	}
+
+	clock.Advance(time.Minute)
+	if err := l.Allow("script"); err != nil {
+		t.Fatalf("Unexpected cool-down after expiration: %v", err)
+	}
+}
+
+func TestChurnLimiter_actor(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	s := store.New(&storage{data: []core.Source{}})
+	s.SetChurnLimiter(&store.ChurnLimiter{
+		Window:   time.Minute,
+		Hard:     3,
+		CoolDown: time.Minute,
+		Clock:    clock,
+	})
+
+	for _, id := range []string{"foo", "bar", "baz"} {
+		if err := s.AppendPolicy(store.NewBlockPolicy("script", id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := s.AppendPolicy(store.NewBlockPolicy("script", "qux"))
+	cerr, ok := err.(*store.ChurnError)
+	if !ok {
+		t.Fatalf("Unexpected error: wanted *store.ChurnError, found %v", err)
+	}
+	if cerr.RetryAfter != time.Minute {
+		t.Fatalf("Unexpected retry after: wanted %v, found %v", time.Minute, cerr.RetryAfter)
+	}
+
+	// Deletions are never refused, not even to an issuer cooling down.
+	if err := s.DelPolicy("script", "block_foo"); err != nil {
+		t.Fatalf("Deletion refused: %v", err)
+	}
+
+	// Removing the policies of another issuer is charged to the
+	// one performing the mutation.
+	if err := s.DelPolicy("user", "block_bar"); err != nil {
+		t.Fatal(err)
+	}
+	records := s.GetChurnSnapshot()
+	if len(records) != 2 || records[1].Issuer != "user" || records[1].Mutations != 1 {
+		t.Fatalf("Unexpected churn snapshot: %+v", records)
+	}
+
+	// Cleaning up while cooling down does not extend the cool-down,
+	// even when the deletions would be enough to start a new one.
+	for _, id := range []string{"x", "y"} {
+		if err := s.AppendPolicy(store.NewBlockPolicy("user_"+id, id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.Advance(30 * time.Second)
+	if err := s.ApplyPolicies("script", []string{"block_baz", "block_x", "block_y"}); err != nil {
+		t.Fatalf("Deletion refused: %v", err)
+	}
+	err = s.AppendPolicy(store.NewBlockPolicy("script", "qux"))
+	if cerr, ok := err.(*store.ChurnError); !ok || cerr.RetryAfter != 30*time.Second {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
@@ -20,6 +20,8 @@ import (
 	"fmt"
 	"net"
 	"time"
+
+	"github.com/booster-proj/booster/match"
 )
 
 type HostResolver interface {
@@ -64,6 +66,10 @@ func (p basePolicy) ID() string {
 	return p.Name
 }
 
+func (p basePolicy) issuedBy() string {
+	return p.Issuer
+}
+
 // GenPolicy is a general purpose policy that allows
 // to configure the behaviour of the Accept function
 // setting its AcceptFunc field.
@@ -114,81 +120,202 @@ func (p *BlockPolicy) Accept(id, address string) bool {
 // ReservedPolicy is a Policy implementation. It is used to reserve a source
 // to be used only for connections to a defined list of addresses, and those
 // connections will not be assigned to any other source.
+//
+// Hosts may also be patterns, see package match.
 type ReservedPolicy struct {
 	basePolicy
 	SourceID string `json:"reserved_source_id"`
+
+	matchers []*match.Matcher
 }
 
-func NewReservedPolicy(issuer, sourceID string, hosts ...string) *ReservedPolicy {
+// NewReservedPolicy creates a ReservedPolicy. It fails if `hosts` is empty
+// or if any of them is not a valid pattern, see package match.
+func NewReservedPolicy(issuer, sourceID string, hosts ...string) (*ReservedPolicy, error) {
+	name := fmt.Sprintf("reserve_%s", sourceID)
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("policy %s: no hosts to reserve", name)
+	}
 	addrs := []string{}
 	for _, v := range hosts {
-		address := TrimPort(v)
-		addrs = append(addrs, LookupAddress(address)...)
+		addrs = append(addrs, LookupAddress(v)...)
+	}
+	matchers, err := compileAll(addrs)
+	if err != nil {
+		return nil, fmt.Errorf("policy %s: %v", name, err)
 	}
 	return &ReservedPolicy{
 		basePolicy: basePolicy{
-			Name:   fmt.Sprintf("reserve_%s", sourceID),
+			Name:   name,
 			Issuer: issuer,
 			Code:   PolicyCodeReserve,
 			Desc:   fmt.Sprintf("source %v will only be used for connections to %v", sourceID, addrs),
 			Addrs:  addrs,
 		},
 		SourceID: sourceID,
-	}
+		matchers: matchers,
+	}, nil
 }
 
 // Accept implements Policy.
 func (p *ReservedPolicy) Accept(id, address string) bool {
-	isIn := false
-	for _, v := range p.Addrs {
-		if address == v {
-			isIn = true
-			break
-		}
+	accept, _, ok := p.verdict(id, address)
+	return !ok || accept
+}
+
+// verdict implements targetPolicy. Outside of its targets, the policy
+// still refuses its source, with the lowest specificity possible.
+func (p *ReservedPolicy) verdict(id, address string) (bool, match.Specificity, bool) {
+	if spec, ok := bestMatch(p.matchers, flow(id, address)); ok {
+		return id == p.SourceID, spec, true
 	}
-	if isIn {
-		return id == p.SourceID
+	if id == p.SourceID {
+		return false, match.Specificity{}, true
 	}
+	return true, match.Specificity{}, false
+}
 
-	return id != p.SourceID
+func (p *ReservedPolicy) decide(id string) (bool, bool) {
+	return id == p.SourceID, true
+}
+
+func (p *ReservedPolicy) source() string {
+	return p.SourceID
+}
+
+func (p *ReservedPolicy) targets() []*match.Matcher {
+	return p.matchers
 }
 
 // AvoidPolicy is a Policy implementation. It is used to avoid giving
-// connection to `Address` to `SourceID`.
+// connection to `Address` to `SourceID`. `Address` may also be a pattern,
+// see package match.
 type AvoidPolicy struct {
 	basePolicy
 	SourceID string `json:"avoid_source_id"`
 	Address  string `json:"address"`
+
+	matchers []*match.Matcher
 }
 
-func NewAvoidPolicy(issuer, sourceID, address string) *AvoidPolicy {
-	address = TrimPort(address)
+// NewAvoidPolicy creates an AvoidPolicy. It fails if `address` is not a
+// valid pattern, see package match.
+func NewAvoidPolicy(issuer, sourceID, address string) (*AvoidPolicy, error) {
+	name := fmt.Sprintf("avoid_%s_for_%s", sourceID, address)
+	addrs := LookupAddress(address)
+	matchers, err := compileAll(addrs)
+	if err != nil {
+		return nil, fmt.Errorf("policy %s: %v", name, err)
+	}
 	return &AvoidPolicy{
 		basePolicy: basePolicy{
-			Name:   fmt.Sprintf("avoid_%s_for_%s", sourceID, address),
+			Name:   name,
 			Issuer: issuer,
 			Code:   PolicyCodeAvoid,
 			Desc:   fmt.Sprintf("source %v will not be used for connections to %s", sourceID, address),
-			Addrs:  LookupAddress(address),
+			Addrs:  addrs,
 		},
 		SourceID: sourceID,
 		Address:  address,
-	}
+		matchers: matchers,
+	}, nil
 }
 
 // Accept implements Policy.
 func (p *AvoidPolicy) Accept(id, address string) bool {
-	isIn := false
-	for _, v := range p.Addrs {
-		if address == v {
-			isIn = true
-			break
+	accept, _, ok := p.verdict(id, address)
+	return !ok || accept
+}
+
+// verdict implements targetPolicy.
+func (p *AvoidPolicy) verdict(id, address string) (bool, match.Specificity, bool) {
+	if id != p.SourceID {
+		return true, match.Specificity{}, false
+	}
+	if spec, ok := bestMatch(p.matchers, flow(id, address)); ok {
+		return false, spec, true
+	}
+	return true, match.Specificity{}, false
+}
+
+func (p *AvoidPolicy) decide(id string) (bool, bool) {
+	return false, id == p.SourceID
+}
+
+func (p *AvoidPolicy) source() string {
+	return p.SourceID
+}
+
+func (p *AvoidPolicy) targets() []*match.Matcher {
+	return p.matchers
+}
+
+// targetPolicy is implemented by the policies that decide about the
+// connections whose target matches one of their patterns, i.e. reserve
+// and avoid policies. When more of them have an opinion about the same
+// connection, only the most specific one applies, see shouldAccept.
+type targetPolicy interface {
+	Policy
+
+	// verdict returns wether the policy accepts `id` for a connection
+	// to `address` and the specificity of the target that matched it.
+	// ok is false if the policy has no opinion about the connection.
+	verdict(id, address string) (accept bool, spec match.Specificity, ok bool)
+
+	// decide returns wether the policy accepts `id` for the connections
+	// matching its targets, and false if it has no opinion about `id`.
+	decide(id string) (accept, ok bool)
+
+	source() string
+	targets() []*match.Matcher
+}
+
+// ConflictError is returned when a policy contradicts another one on the
+// same target, hence it is not possible to tell which one should apply.
+type ConflictError struct {
+	Policy string
+	With   string
+	Target string
+}
+
+func (err *ConflictError) Error() string {
+	return fmt.Sprintf("source store: policy %s conflicts with policy %s on target %s", err.Policy, err.With, err.Target)
+}
+
+// conflict returns a ConflictError if `p` and `q` share a target and
+// take different decisions about one of their sources. Policies with
+// targets that only overlap partially do not conflict, the most specific
+// target, or the oldest policy, wins.
+func conflict(p, q targetPolicy) error {
+	for _, m := range p.targets() {
+		for _, n := range q.targets() {
+			if match.Canonical(m.String()) != match.Canonical(n.String()) {
+				continue
+			}
+			for _, id := range []string{p.source(), q.source()} {
+				a, aok := p.decide(id)
+				b, bok := q.decide(id)
+				if aok && bok && a != b {
+					return &ConflictError{Policy: p.ID(), With: q.ID(), Target: m.String()}
+				}
+			}
 		}
 	}
-	if isIn {
-		return id != p.SourceID
+	return nil
+}
+
+// bestMatch returns the specificity of the most specific of `matchers`
+// matching `f`, and false if none does.
+func bestMatch(matchers []*match.Matcher, f match.Flow) (best match.Specificity, ok bool) {
+	for _, m := range matchers {
+		if !m.MatchFlow(f) {
+			continue
+		}
+		if spec := m.Specificity(); !ok || spec.Compare(best) > 0 {
+			best, ok = spec, true
+		}
 	}
-	return true
+	return best, ok
 }
 
 // HistoryQueryFunc describes the function that is used to query the bind
@@ -202,6 +329,15 @@ type HistoryQueryFunc func(string) (string, bool)
 type StickyPolicy struct {
 	basePolicy
 	BindHistory HistoryQueryFunc `json:"-"`
+
+	// Pins, if set, is queried before BindHistory. Its bindings
+	// apply also to the addresses matching the exceptions.
+	Pins HistoryQueryFunc `json:"-"`
+
+	// Exceptions is the list of patterns, see package match,
+	// describing the addresses that are not bound to any source.
+	Exceptions []string `json:"exceptions,omitempty"`
+	exceptions []*match.Matcher
 }
 
 func NewStickyPolicy(issuer string, f HistoryQueryFunc) *StickyPolicy {
@@ -216,9 +352,33 @@ func NewStickyPolicy(issuer string, f HistoryQueryFunc) *StickyPolicy {
 	}
 }
 
+// Except excludes the addresses matching `patterns` from the policy.
+func (p *StickyPolicy) Except(patterns ...string) error {
+	acc := make([]*match.Matcher, 0, len(patterns))
+	for _, v := range patterns {
+		m, err := match.Compile(v)
+		if err != nil {
+			return err
+		}
+		acc = append(acc, m)
+	}
+	p.Exceptions = append(p.Exceptions, patterns...)
+	p.exceptions = append(p.exceptions, acc...)
+	return nil
+}
+
 // Accept implements Policy.
 func (p *StickyPolicy) Accept(id, address string) bool {
-	if hid, ok := p.BindHistory(address); ok {
+	if p.Pins != nil {
+		if pid, ok := p.Pins(address); ok {
+			return id == pid
+		}
+	}
+	if match.AnyFlow(p.exceptions, flow(id, address)) {
+		return true
+	}
+	// The history is keyed by host, regardless of the port.
+	if hid, ok := p.BindHistory(TrimPort(address)); ok {
 		return id == hid
 	}
 
@@ -234,16 +394,49 @@ func TrimPort(address string) string {
 	return address
 }
 
-// LookupAddress finds the addresses associated with `address`. If it
-// is not able to lookup, it just returns `address` wrapped into a list.
+// LookupAddress finds the addresses associated with `address`, keeping
+// its port, if any. If it is not able to lookup, or `address` is a
+// pattern, it just returns `address` wrapped into a list.
 func LookupAddress(address string) []string {
-	address = TrimPort(address)
+	if match.IsPattern(address) {
+		return []string{address}
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = address, ""
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
-	names, err := Resolver.LookupHost(ctx, address)
+	names, err := Resolver.LookupHost(ctx, host)
 	if err != nil {
 		return []string{address}
 	}
-	return names
+	if port == "" {
+		return names
+	}
+	acc := make([]string, len(names))
+	for i, v := range names {
+		acc[i] = net.JoinHostPort(v, port)
+	}
+	return acc
+}
+
+// flow builds the match.Flow describing a connection to `address`
+// through source `id`, which is exposed to the patterns as the "source"
+// label.
+func flow(id, address string) match.Flow {
+	return match.Flow{Target: address, Labels: map[string]string{"source": id}}
+}
+
+func compileAll(patterns []string) ([]*match.Matcher, error) {
+	acc := make([]*match.Matcher, 0, len(patterns))
+	for _, v := range patterns {
+		m, err := match.Compile(v)
+		if err != nil {
+			return nil, err
+		}
+		acc = append(acc, m)
+	}
+	return acc, nil
 }
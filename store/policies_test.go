@@ -78,7 +78,10 @@ func TestReservedPolicy(t *testing.T) {
 	t1 := "host1"
 	t2 := "host2"
 
-	p := store.NewReservedPolicy("T", s0.ID(), t0)
+	p, err := store.NewReservedPolicy("T", s0.ID(), t0)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if ok := p.Accept(s0.ID(), t0); !ok {
 		t.Fatalf("Policy %s did not accept source %v for address %s", p.ID(), s0.ID(), t0)
 	}
@@ -93,7 +96,10 @@ func TestReservedPolicy(t *testing.T) {
 	}
 
 	// reserved policy with multiple addresses
-	p = store.NewReservedPolicy("T", s0.ID(), t0, t1)
+	p, err = store.NewReservedPolicy("T", s0.ID(), t0, t1)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if ok := p.Accept(s0.ID(), t0); !ok {
 		t.Fatalf("Policy %s did not accept source %v for address %s", p.ID(), s0.ID(), t0)
 	}
@@ -109,7 +115,10 @@ func TestAvoidPolicy(t *testing.T) {
 	t0 := "host0"
 	t1 := "host1"
 
-	p := store.NewAvoidPolicy("T", s0.ID(), t0)
+	p, err := store.NewAvoidPolicy("T", s0.ID(), t0)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if ok := p.Accept(s0.ID(), t0); ok {
 		t.Fatalf("Policy %s accepted source %v for address %s", p.ID(), s0.ID(), t0)
 	}
@@ -124,6 +133,52 @@ func TestAvoidPolicy(t *testing.T) {
 	}
 }
 
+func TestAvoidPolicy_pattern(t *testing.T) {
+	store.Resolver = resolver{addrs: []string{"must-not-be-used"}}
+	s0 := &mock{id: "foo"}
+
+	tt := []struct {
+		target  string
+		address string
+		accept  bool
+	}{
+		{target: "10.0.0.0/8:443", address: "10.1.2.3:443", accept: false},
+		{target: "10.0.0.0/8:443", address: "10.1.2.3:80", accept: true},
+		{target: "*:443", address: "example.com:80", accept: true},
+		{target: "*:443", address: "example.com:443", accept: false},
+		{target: ".bücher.de", address: "shop.xn--bcher-kva.de:443", accept: false},
+		{target: "*;source=foo", address: "example.com", accept: false},
+		{target: "*;source=bar", address: "example.com", accept: true},
+		{target: "10.0.0.0/8", address: "192.168.1.1", accept: true},
+		{target: "*.example.com", address: "api.example.com", accept: false},
+		{target: "*.example.com", address: "example.com", accept: true},
+		{target: ".example.com", address: "Example.COM.", accept: false},
+	}
+
+	for i, v := range tt {
+		p, err := store.NewAvoidPolicy("T", s0.ID(), v.target)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if ok := p.Accept(s0.ID(), v.address); ok != v.accept {
+			t.Fatalf("%d: unexpected accept value for %s, target %s: wanted %v, found %v", i, v.address, v.target, v.accept, ok)
+		}
+	}
+}
+
+func TestNewPolicy_invalid(t *testing.T) {
+	store.Resolver = resolver{}
+	if p, err := store.NewAvoidPolicy("T", "foo", "10.0.0.0/33"); err == nil {
+		t.Fatalf("Expected error creating avoid policy, found %+v", p)
+	}
+	if p, err := store.NewReservedPolicy("T", "foo", "host0", "example.com:http"); err == nil {
+		t.Fatalf("Expected error creating reserved policy, found %+v", p)
+	}
+	if p, err := store.NewReservedPolicy("T", "foo"); err == nil {
+		t.Fatalf("Expected error creating reserved policy without hosts, found %+v", p)
+	}
+}
+
 func TestStickyPolicy(t *testing.T) {
 	store.Resolver = resolver{}
 	s0 := &mock{id: "foo"}
@@ -164,3 +219,41 @@ func TestStickyPolicy(t *testing.T) {
 		t.Fatalf("Policy %s did not accept source %v for address %s", p.ID(), s1.ID(), t1)
 	}
 }
+
+func TestStickyPolicy_exceptions(t *testing.T) {
+	s0 := &mock{id: "foo"}
+	s1 := &mock{id: "bar"}
+	t0 := "speedtest.example.com"
+	t1 := "pinned.example.com"
+
+	history := map[string]string{t0: s0.ID(), t1: s0.ID()}
+	p := store.NewStickyPolicy("T", func(address string) (src string, ok bool) {
+		src, ok = history[address]
+		return
+	})
+	p.Pins = func(address string) (string, bool) {
+		if address == t1 {
+			return s1.ID(), true
+		}
+		return "", false
+	}
+	if err := p.Except("[::1"); err == nil {
+		t.Fatalf("Expected error with invalid exception")
+	}
+	if err := p.Except("*.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Learned history is ignored for excepted targets.
+	if ok := p.Accept(s1.ID(), t0); !ok {
+		t.Fatalf("Policy %s did not accept source %v for address %s", p.ID(), s1.ID(), t0)
+	}
+
+	// The pin wins over both the exception and the history.
+	if ok := p.Accept(s1.ID(), t1); !ok {
+		t.Fatalf("Policy %s did not accept source %v for address %s", p.ID(), s1.ID(), t1)
+	}
+	if ok := p.Accept(s0.ID(), t1); ok {
+		t.Fatalf("Policy %s accepted source %v for address %s", p.ID(), s0.ID(), t1)
+	}
+}
@@ -24,12 +24,17 @@ package store
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/booster-proj/booster/core"
+	"github.com/booster-proj/booster/match"
 	"upspin.io/log"
 )
 
@@ -38,6 +43,7 @@ import (
 type Store interface {
 	Put(...core.Source)
 	Del(...core.Source)
+	ReplaceAll(...core.Source)
 	Get(context.Context, ...core.Source) (core.Source, error)
 
 	Len() int
@@ -45,7 +51,8 @@ type Store interface {
 }
 
 // A Policy defines wether a connection to `address` should
-// be accepted by source `id`.
+// be accepted by source `id`. `address` is the connection target
+// as received, port included when known.
 type Policy interface {
 	ID() string
 	Accept(id, address string) bool
@@ -58,22 +65,83 @@ type Policy interface {
 type SourceStore struct {
 	protected Store
 
+	// policies holds an immutable []Policy snapshot. Readers load
+	// it without locking, writers serialize on the mutex and swap
+	// in a freshly built slice once their mutation is complete.
 	policies struct {
 		sync.Mutex
-		val []Policy
+		val atomic.Value
 	}
+	// churn is protected by the policies mutex.
+	churn *ChurnLimiter
+
 	bindHistory struct {
 		sync.Mutex
 		record bool
 		val    map[string]string
 	}
+	pins struct {
+		sync.Mutex
+		val  []*Pin
+		path string
+	}
+	// changed is closed and replaced each time the sources, the
+	// policies or the pins change.
+	changed struct {
+		sync.Mutex
+		c chan struct{}
+	}
 }
 
-// DummySource is a representation of a source, suitable
+// Pin is a manual binding between the addresses matching Target and a
+// source. Pins are used by the sticky policy, and take precedence over
+// the bindings it learns.
+type Pin struct {
+	// Target is the pattern, see package match, describing the
+	// addresses pinned.
+	Target   string `json:"target"`
+	SourceID string `json:"source_id"`
+	Issuer   string `json:"issuer"`
+
+	m *match.Matcher
+}
+
+// SourceRecord is a representation of a source, suitable
 // when other components need information about the sources stored,
 // but should not be able to mess with it's actual content.
-type DummySource struct {
-	ID string `json:"name"`
+type SourceRecord struct {
+	// ID uniquely identifies the source.
+	ID string `json:"id"`
+	// Name is the name of the source. It currently matches ID, and it is
+	// kept as the field used by the DummySource encoding.
+	Name string `json:"name"`
+	// OpenConns is the number of connections open through the source, or
+	// -1 if the source is not able to report it.
+	OpenConns int `json:"open_conns"`
+	// Blocked tells wether the source is excluded by a block policy.
+	Blocked bool `json:"blocked"`
+	// LastCheck is when the connection of the source was last checked
+	// successfully, and CheckLatency how long that check took. Both
+	// are omitted if the source is not able to report them.
+	LastCheck    *time.Time    `json:"last_check,omitempty"`
+	CheckLatency time.Duration `json:"check_latency_ns,omitempty"`
+}
+
+// DummySource is the former name of SourceRecord.
+//
+// Deprecated: use SourceRecord instead.
+type DummySource = SourceRecord
+
+// ConnCounter is implemented by sources that are able to report
+// the number of connections they currently have open.
+type ConnCounter interface {
+	Len() int
+}
+
+// CheckReporter is implemented by sources that are able to report
+// their last successful connection check, and how long it took.
+type CheckReporter interface {
+	LastCheck() (time.Time, time.Duration)
 }
 
 // New creates a New instance of SourceStore, using interally `store`
@@ -91,8 +159,6 @@ func New(store Store) *SourceStore {
 // If `bindHistory.record == true`, the source identifier returned for this address
 // is saved into `bindHistory.val`.
 func (ss *SourceStore) Get(ctx context.Context, address string, blacklisted ...core.Source) (core.Source, error) {
-	address = TrimPort(address)
-
 	// Combine blacklist received with the one composed by
 	// the policies.
 	blacklisted = append(blacklisted, ss.MakeBlacklist(address)...)
@@ -105,11 +171,19 @@ func (ss *SourceStore) Get(ctx context.Context, address string, blacklisted ...c
 
 	ctx, cancel := context.WithTimeout(ctx, time.Second)
 	defer cancel()
-	ss.SaveBindHistory(ctx, src.ID(), address)
+	ss.SaveBindHistory(ctx, src.ID(), TrimPort(address))
 
 	return src, nil
 }
 
+// Report forwards `o` to the protected storage, if it is able to handle
+// outcomes, e.g. to let its strategy learn from them.
+func (ss *SourceStore) Report(o core.Outcome) {
+	if r, ok := ss.protected.(interface{ Report(core.Outcome) }); ok {
+		r.Report(o)
+	}
+}
+
 // SaveBindHistory saves the association of an address with a source. It
 // performs the operation only if it is required, as this is a time
 // consuming operation (potentially, due to DNS lookup).
@@ -158,23 +232,37 @@ func (ss *SourceStore) SaveBindHistory(ctx context.Context, id, address string)
 // ShouldAccept takes `id` and `address`, iterates through the list of policies
 // and returns false if the two inputs are not accepted by one of them. The
 // offending policy is also returned.
+// Among the reserve and avoid policies that have an opinion about `id` and
+// `address`, only the one with the most specific matching target applies,
+// the oldest one in case of a tie: e.g. a reservation of 10.8.3.0/24 overrides
+// one of 10.8.0.0/16 for the addresses they have in common.
 // Returns true if no policy blocks `id` and `address`.
 func (ss *SourceStore) ShouldAccept(id, address string) (bool, Policy) {
-	ss.policies.Lock()
-	defer ss.policies.Unlock()
-
-	if ss.policies.val == nil {
-		return true, nil
-	}
+	return shouldAccept(ss.loadPolicies(), id, address)
+}
 
-	// remove port from address if it is present
-	address = TrimPort(address)
-	for _, p := range ss.policies.val {
+func shouldAccept(policies []Policy, id, address string) (bool, Policy) {
+	var (
+		winner targetPolicy
+		spec   match.Specificity
+		accept bool
+	)
+	for _, p := range policies {
+		if tp, ok := p.(targetPolicy); ok {
+			a, s, has := tp.verdict(id, address)
+			if has && (winner == nil || s.Compare(spec) > 0) {
+				winner, spec, accept = tp, s, a
+			}
+			continue
+		}
 		ok := p.Accept(id, address)
 		if !ok {
 			return ok, p
 		}
 	}
+	if winner != nil && !accept {
+		return false, winner
+	}
 
 	return true, nil
 }
@@ -182,21 +270,19 @@ func (ss *SourceStore) ShouldAccept(id, address string) (bool, Policy) {
 // MakeBlacklist computes the list of blacklisted sources for `address`, i.e. the
 // sources that should not be used to perform a request to `address`, because there
 // is one or more policies that do not accept them.
+// Every source is evaluated against the same policy snapshot, hence the blacklist
+// never reflects a partially applied policy mutation.
 func (ss *SourceStore) MakeBlacklist(address string) []core.Source {
 	acc := make([]core.Source, 0, ss.Len())
 
 	// return immediately if there is no policy.
-	ss.policies.Lock()
-	l := len(ss.policies.val)
-	ss.policies.Unlock()
-
-	if l == 0 {
+	policies := ss.loadPolicies()
+	if len(policies) == 0 {
 		return acc
 	}
 
-	address = TrimPort(address)
 	ss.Do(func(src core.Source) {
-		if ok, _ := ss.ShouldAccept(src.ID(), address); !ok {
+		if ok, _ := shouldAccept(policies, src.ID(), address); !ok {
 			acc = append(acc, src)
 		}
 	})
@@ -214,61 +300,145 @@ func (ss *SourceStore) Do(f func(core.Source)) {
 	ss.protected.Do(f)
 }
 
-// AppendPolicy appends `p` to the end of the list of policies.
+// AppendPolicy appends `p` to the end of the list of policies, on behalf
+// of the issuer of `p`.
 func (ss *SourceStore) AppendPolicy(p Policy) error {
+	return ss.ApplyPolicies(issuerOf(p), nil, p)
+}
+
+// DelPolicy removes the policy with identifier `id` from the storage, on
+// behalf of `issuer`.
+func (ss *SourceStore) DelPolicy(issuer, id string) error {
+	return ss.ApplyPolicies(issuer, []string{id})
+}
+
+// ApplyPolicies removes the policies identified by `del` and then appends
+// `add` to the end of the list of policies, as a single mutation: concurrent
+// evaluations either observe the entire old set or the entire new one.
+// If any identifier in `del` is not found, or any policy in `add` would be a
+// duplicate or would conflict with another one, see ConflictError, no change
+// is applied at all.
+// The mutation is accounted to `issuer`, the one performing it, regardless
+// of who issued the policies involved. Mutations that only remove policies
+// are never refused by the ChurnLimiter.
+func (ss *SourceStore) ApplyPolicies(issuer string, del []string, add ...Policy) error {
 	ss.policies.Lock()
 	defer ss.policies.Unlock()
 
-	if ss.policies.val == nil {
-		ss.policies.val = make([]Policy, 0, 1)
+	old := ss.loadPolicies()
+	if len(del) > 0 && len(old) == 0 {
+		return fmt.Errorf("source store: no policies stored")
 	}
 
-	// Ensure that this is not a duplicate.
-	for _, v := range ss.policies.val {
-		if v.ID() == p.ID() {
-			return fmt.Errorf("source store: a policy with identifier %v is already present", v.ID())
+	// Build the new snapshot from scratch, the old one might
+	// still be in use by some reader.
+	acc := make([]Policy, 0, len(old)+len(add))
+	removed := make(map[string]bool, len(del))
+	for _, id := range del {
+		removed[id] = false
+	}
+	dropped := make([]Policy, 0, len(del))
+	for _, v := range old {
+		if _, ok := removed[v.ID()]; ok {
+			removed[v.ID()] = true
+			dropped = append(dropped, v)
+			continue
+		}
+		acc = append(acc, v)
+	}
+	for _, id := range del {
+		if !removed[id] {
+			return fmt.Errorf("source store: no %s policy found", id)
+		}
+	}
+
+	// Ensure that there are no duplicates, nor conflicting policies.
+	for _, p := range add {
+		for _, v := range acc {
+			if v.ID() == p.ID() {
+				return fmt.Errorf("source store: a policy with identifier %v is already present", v.ID())
+			}
+		}
+		if tp, ok := p.(targetPolicy); ok {
+			for _, v := range acc {
+				if tv, ok := v.(targetPolicy); ok {
+					if err := conflict(tp, tv); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		acc = append(acc, p)
+	}
+
+	if l := ss.churn; l != nil && len(add) > 0 {
+		if err := l.Allow(issuer); err != nil {
+			return err
 		}
 	}
 
-	// Eventually append the new policy.
-	ss.policies.val = append(ss.policies.val, p)
-	if p.ID() == "stick" {
-		ss.RecordBindHistory()
+	ss.policies.val.Store(acc)
+	ss.notify()
+	added, deleted := policyIDs(add), policyIDs(dropped)
+	log.Info.Printf("SourceStore: issuer %q added policies %v, removed policies %v", issuer, added, deleted)
+	if l := ss.churn; l != nil {
+		l.Record(issuer, added, deleted)
+	}
+
+	if _, ok := removed["stick"]; ok {
+		ss.StopRecordingBindHistory()
+	}
+	for _, p := range add {
+		if p.ID() == "stick" {
+			ss.RecordBindHistory()
+		}
 	}
 
 	return nil
 }
 
-// DelPolicy removes the policy with identifier `id` from the storage.
-func (ss *SourceStore) DelPolicy(id string) error {
+// SetChurnLimiter makes the store refuse policy mutations from the
+// issuers that are put in cool-down by `l`. Pass nil to disable the
+// limiter.
+func (ss *SourceStore) SetChurnLimiter(l *ChurnLimiter) {
 	ss.policies.Lock()
 	defer ss.policies.Unlock()
+	ss.churn = l
+}
 
-	if ss.policies.val == nil {
-		return fmt.Errorf("source store: no policies stored")
-	}
+// GetChurnSnapshot returns the policy churn of each issuer, or nil if
+// no ChurnLimiter is set.
+func (ss *SourceStore) GetChurnSnapshot() []ChurnRecord {
+	ss.policies.Lock()
+	l := ss.churn
+	ss.policies.Unlock()
 
-	// Remove the policy from the storage.
-	var j int
-	var found bool
-	for i, v := range ss.policies.val {
-		if v.ID() == id {
-			found = true
-			j = i
-			break
-		}
+	if l == nil {
+		return nil
 	}
-	if !found {
-		return fmt.Errorf("source store: no %s policy found", id)
+	return l.Snapshot()
+}
+
+func issuerOf(p Policy) string {
+	if v, ok := p.(interface{ issuedBy() string }); ok {
+		return v.issuedBy()
 	}
-	// avoid any possible memory leak in the underlying array.
-	ss.policies.val[j] = nil
-	ss.policies.val = append(ss.policies.val[:j], ss.policies.val[j+1:]...)
-	if id == "stick" {
-		ss.StopRecordingBindHistory()
+	return ""
+}
+
+func policyIDs(policies []Policy) []string {
+	acc := make([]string, len(policies))
+	for i, p := range policies {
+		acc[i] = p.ID()
 	}
+	return acc
+}
 
-	return nil
+// loadPolicies returns the current policy snapshot. The returned
+// slice must not be modified.
+func (ss *SourceStore) loadPolicies() []Policy {
+	policies, _ := ss.policies.val.Load().([]Policy)
+	return policies
 }
 
 // Put adds `sources` to the protected storage.
@@ -277,6 +447,7 @@ func (ss *SourceStore) Put(sources ...core.Source) {
 	defer ss.policies.Unlock()
 
 	ss.protected.Put(sources...)
+	ss.notify()
 }
 
 // Del removes `sources` from the protected storage.
@@ -285,28 +456,78 @@ func (ss *SourceStore) Del(sources ...core.Source) {
 	defer ss.policies.Unlock()
 
 	ss.protected.Del(sources...)
+	ss.notify()
 }
 
-// GetPoliciesSnapshot returns a copy of the current policies
-// active in the store.
-func (ss *SourceStore) GetPoliciesSnapshot() []Policy {
+// ReplaceAll replaces the content of the protected storage with `sources`
+// using a single operation, see core.Balancer.ReplaceAll.
+func (ss *SourceStore) ReplaceAll(sources ...core.Source) {
 	ss.policies.Lock()
 	defer ss.policies.Unlock()
 
-	acc := make([]Policy, len(ss.policies.val))
-	copy(acc, ss.policies.val)
+	ss.protected.ReplaceAll(sources...)
+	ss.notify()
+}
+
+// Changed returns a channel that is closed the next time the stored
+// sources, the policies or the pins change, i.e. when the result of
+// Get might be different.
+func (ss *SourceStore) Changed() <-chan struct{} {
+	ss.changed.Lock()
+	defer ss.changed.Unlock()
+	if ss.changed.c == nil {
+		ss.changed.c = make(chan struct{})
+	}
+	return ss.changed.c
+}
+
+func (ss *SourceStore) notify() {
+	ss.changed.Lock()
+	defer ss.changed.Unlock()
+	if ss.changed.c != nil {
+		close(ss.changed.c)
+		ss.changed.c = nil
+	}
+}
+
+// GetPoliciesSnapshot returns a copy of the current policies
+// active in the store.
+func (ss *SourceStore) GetPoliciesSnapshot() []Policy {
+	policies := ss.loadPolicies()
+	acc := make([]Policy, len(policies))
+	copy(acc, policies)
 	return acc
 }
 
-// GetSourcesSnapshot returns nothing more then a copy of the
-// list of sources that the storage is holding.
-func (ss *SourceStore) GetSourcesSnapshot() []*DummySource {
-	acc := make([]*DummySource, 0, ss.protected.Len())
+// GetSourcesSnapshot returns a record for each source that the
+// storage is holding, combined with the state kept by the store.
+func (ss *SourceStore) GetSourcesSnapshot() []*SourceRecord {
+	blocked := make(map[string]bool)
+	for _, p := range ss.loadPolicies() {
+		if bp, ok := p.(*BlockPolicy); ok {
+			blocked[bp.SourceID] = true
+		}
+	}
 
+	acc := make([]*SourceRecord, 0, ss.protected.Len())
 	ss.protected.Do(func(src core.Source) {
-		acc = append(acc, &DummySource{
-			ID: src.ID(),
-		})
+		conns := -1
+		if c, ok := src.(ConnCounter); ok {
+			conns = c.Len()
+		}
+		rec := &SourceRecord{
+			ID:        src.ID(),
+			Name:      src.ID(),
+			OpenConns: conns,
+			Blocked:   blocked[src.ID()],
+		}
+		if c, ok := src.(CheckReporter); ok {
+			if at, d := c.LastCheck(); !at.IsZero() {
+				rec.LastCheck = &at
+				rec.CheckLatency = d
+			}
+		}
+		acc = append(acc, rec)
 	})
 
 	return acc
@@ -344,3 +565,149 @@ func (ss *SourceStore) QueryBindHistory(address string) (src string, ok bool) {
 	src, ok = ss.bindHistory.val[address]
 	return
 }
+
+// AddPin binds the addresses matching `target` to source `id`. It replaces
+// the pin with the same target, if any. Targets are stored and compared in
+// their canonical form, see match.Canonical.
+func (ss *SourceStore) AddPin(issuer, target, id string) (*Pin, error) {
+	target = match.Canonical(target)
+	m, err := match.Compile(target)
+	if err != nil {
+		return nil, err
+	}
+	pin := &Pin{Target: target, SourceID: id, Issuer: issuer, m: m}
+
+	ss.pins.Lock()
+	defer ss.pins.Unlock()
+	acc := make([]*Pin, 0, len(ss.pins.val)+1)
+	replaced := false
+	for _, v := range ss.pins.val {
+		if v.Target == target {
+			v, replaced = pin, true
+		}
+		acc = append(acc, v)
+	}
+	if !replaced {
+		acc = append(acc, pin)
+	}
+	if err := ss.storePins(acc); err != nil {
+		return nil, err
+	}
+	return pin, nil
+}
+
+// DelPin removes the pin with `target`.
+func (ss *SourceStore) DelPin(target string) error {
+	target = match.Canonical(target)
+
+	ss.pins.Lock()
+	defer ss.pins.Unlock()
+	for i, v := range ss.pins.val {
+		if v.Target == target {
+			acc := make([]*Pin, 0, len(ss.pins.val)-1)
+			acc = append(acc, ss.pins.val[:i]...)
+			acc = append(acc, ss.pins.val[i+1:]...)
+			return ss.storePins(acc)
+		}
+	}
+	return fmt.Errorf("source store: no pin with target %s found", target)
+}
+
+// SetPinsFile makes the pins survive restarts: the ones saved at `path`,
+// if any, replace the current ones, and every following change is saved
+// there before being applied.
+func (ss *SourceStore) SetPinsFile(path string) error {
+	ss.pins.Lock()
+	defer ss.pins.Unlock()
+
+	pins, err := readPins(path)
+	if err != nil {
+		return fmt.Errorf("source store: unable to load pins: %v", err)
+	}
+	if pins != nil {
+		ss.pins.val = pins
+		ss.notify()
+	}
+	ss.pins.path = path
+	return nil
+}
+
+// storePins replaces the pins with `pins`, saving them first if a pins
+// file is set. The caller must hold the pins mutex.
+func (ss *SourceStore) storePins(pins []*Pin) error {
+	if path := ss.pins.path; path != "" {
+		if err := writePins(path, pins); err != nil {
+			return fmt.Errorf("source store: unable to save pins: %v", err)
+		}
+	}
+	ss.pins.val = pins
+	ss.notify()
+	return nil
+}
+
+type pinsFile struct {
+	Pins []*Pin `json:"pins"`
+}
+
+// readPins returns the pins saved at `path`, or nil if the file
+// does not exist.
+func readPins(path string) ([]*Pin, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f pinsFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	acc := make([]*Pin, 0, len(f.Pins))
+	for _, v := range f.Pins {
+		if v.m, err = match.Compile(v.Target); err != nil {
+			return nil, err
+		}
+		acc = append(acc, v)
+	}
+	return acc, nil
+}
+
+// writePins saves `pins` at `path`, replacing the file atomically.
+func writePins(path string, pins []*Pin) error {
+	b, err := json.MarshalIndent(pinsFile{Pins: pins}, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// GetPinsSnapshot returns a copy of the pins, in the order they were
+// added.
+func (ss *SourceStore) GetPinsSnapshot() []Pin {
+	ss.pins.Lock()
+	defer ss.pins.Unlock()
+	acc := make([]Pin, len(ss.pins.val))
+	for i, v := range ss.pins.val {
+		acc[i] = *v
+	}
+	return acc
+}
+
+// QueryPin returns the source pinned to `address`. When more than one
+// pin matches, the one that was added first is used.
+func (ss *SourceStore) QueryPin(address string) (src string, ok bool) {
+	ss.pins.Lock()
+	defer ss.pins.Unlock()
+	for _, v := range ss.pins.val {
+		if v.m.Match(address) {
+			return v.SourceID, true
+		}
+	}
+	return "", false
+}
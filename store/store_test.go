@@ -18,13 +18,27 @@ package store_test
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/booster-proj/booster/core"
 	"github.com/booster-proj/booster/store"
+	"github.com/booster-proj/booster/testutil"
 )
 
+// core.Balancer is the reference store.Store implementation.
+func TestStore_balancer(t *testing.T) {
+	testutil.TestStore(t, func() store.Store {
+		return new(core.Balancer)
+	})
+}
+
 func TestSaveBindHistory(t *testing.T) {
 	ip0 := "192.168.0.61"
 	ip1 := "192.168.0.62:443"
@@ -89,8 +103,7 @@ func TestGet(t *testing.T) {
 		AcceptFunc: func(id, target string) bool {
 			// Does not accept s0 trying to contact t0
 			t.Logf("AcceptFunc called with: id(%s) target(%s)", id, target)
-			trg := store.TrimPort(t0)
-			return !(id == s0.ID() && target == trg)
+			return !(id == s0.ID() && target == t0)
 		},
 	})
 
@@ -137,8 +150,7 @@ func TestMakeBlacklist(t *testing.T) {
 		AcceptFunc: func(id, target string) bool {
 			// Does not accept s0 trying to contact t0
 			t.Logf("AcceptFunc called with: id(%s) target(%s)", id, target)
-			trg := store.TrimPort(t0)
-			return !(id == s0.ID() && target == trg)
+			return !(id == s0.ID() && target == t0)
 		},
 	})
 
@@ -169,7 +181,10 @@ func TestMakeBlacklist_ipify(t *testing.T) {
 	}
 
 	// ipify connections can only be dialed with en0
-	rp := store.NewReservedPolicy("T", en0.ID(), "api.ipify.org")
+	rp, err := store.NewReservedPolicy("T", en0.ID(), "api.ipify.org")
+	if err != nil {
+		t.Fatal(err)
+	}
 	s.AppendPolicy(rp)
 	if bl := s.MakeBlacklist(t0); len(bl) != 1 {
 		t.Fatalf("Unexpected blacklist content: wanted [%s], found %+v", en4, bl)
@@ -177,10 +192,13 @@ func TestMakeBlacklist_ipify(t *testing.T) {
 	if bl := s.MakeBlacklist(t1); len(bl) != 1 {
 		t.Fatalf("Unexpected blacklist content: wanted [%s], found %+v", en0, bl)
 	}
-	s.DelPolicy(rp.ID())
+	s.DelPolicy("T", rp.ID())
 
 	// ipify connections CANNOT be dialed with en0
-	ap := store.NewAvoidPolicy("T", en0.ID(), "api.ipify.org")
+	ap, err := store.NewAvoidPolicy("T", en0.ID(), "api.ipify.org")
+	if err != nil {
+		t.Fatal(err)
+	}
 	s.AppendPolicy(ap)
 	if bl := s.MakeBlacklist(t0); len(bl) != 1 {
 		t.Fatalf("Unexpected blacklist content: wanted [%s], found %+v", en0, bl)
@@ -190,6 +208,212 @@ func TestMakeBlacklist_ipify(t *testing.T) {
 	}
 }
 
+func TestMakeBlacklist_port(t *testing.T) {
+	en0 := &mock{id: "en0"}
+	en4 := &mock{id: "en4"}
+	store.Resolver = resolver{addrs: []string{"93.184.216.34"}}
+
+	s := store.New(&storage{data: []core.Source{en0, en4}})
+	for _, v := range []struct{ id, target string }{
+		{en0.ID(), "*:443"},
+		{en4.ID(), "example.org:8080"},
+	} {
+		p, err := store.NewAvoidPolicy("T", v.id, v.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.AppendPolicy(p)
+	}
+
+	if bl := s.MakeBlacklist("example.com:80"); len(bl) != 0 {
+		t.Fatalf("Unexpected blacklist content: wanted [], found %+v", bl)
+	}
+	if bl := s.MakeBlacklist("example.com:443"); len(bl) != 1 || bl[0] != en0 {
+		t.Fatalf("Unexpected blacklist content: wanted [%s], found %+v", en0, bl)
+	}
+	// Resolved addresses keep the port of the pattern.
+	if bl := s.MakeBlacklist("93.184.216.34:8080"); len(bl) != 1 || bl[0] != en4 {
+		t.Fatalf("Unexpected blacklist content: wanted [%s], found %+v", en4, bl)
+	}
+	if bl := s.MakeBlacklist("93.184.216.34:80"); len(bl) != 0 {
+		t.Fatalf("Unexpected blacklist content: wanted [], found %+v", bl)
+	}
+}
+
+// permutations returns all the orderings of `policies`.
+func permutations(policies []store.Policy) [][]store.Policy {
+	if len(policies) <= 1 {
+		return [][]store.Policy{policies}
+	}
+	var acc [][]store.Policy
+	for i := range policies {
+		rest := make([]store.Policy, 0, len(policies)-1)
+		rest = append(rest, policies[:i]...)
+		rest = append(rest, policies[i+1:]...)
+		for _, v := range permutations(rest) {
+			acc = append(acc, append([]store.Policy{policies[i]}, v...))
+		}
+	}
+	return acc
+}
+
+func allowed(s *store.SourceStore, address string) []string {
+	bl := s.MakeBlacklist(address)
+	acc := []string{}
+	s.Do(func(src core.Source) {
+		for _, v := range bl {
+			if v.ID() == src.ID() {
+				return
+			}
+		}
+		acc = append(acc, src.ID())
+	})
+	return acc
+}
+
+func TestMakeBlacklist_specificity(t *testing.T) {
+	store.Resolver = resolver{}
+	sources := []core.Source{&mock{id: "a"}, &mock{id: "b"}, &mock{id: "c"}, &mock{id: "d"}}
+	var policies []store.Policy
+	for _, v := range []struct {
+		reserve    bool
+		id, target string
+	}{
+		{true, "a", "10.8.0.0/16"},
+		{true, "b", "10.8.3.0/24"},
+		{true, "c", "10.8.3.7"},
+		{false, "a", "10.8.200.0/24"},
+		{false, "d", "*:443"},
+	} {
+		var p store.Policy
+		var err error
+		if v.reserve {
+			p, err = store.NewReservedPolicy("T", v.id, v.target)
+		} else {
+			p, err = store.NewAvoidPolicy("T", v.id, v.target)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		policies = append(policies, p)
+	}
+
+	tt := []struct {
+		address string
+		allowed []string
+	}{
+		{"10.8.3.7:443", []string{"c"}},
+		{"10.8.3.9:443", []string{"b"}},
+		{"10.8.9.9:443", []string{"a"}},
+		{"10.8.200.1:80", []string{}},
+		{"192.168.1.1:80", []string{"d"}},
+		{"192.168.1.1:443", []string{}},
+	}
+
+	// There are no ties, hence the result must not depend on the
+	// order in which the policies were added.
+	for _, perm := range permutations(policies) {
+		s := store.New(&storage{data: sources})
+		for _, p := range perm {
+			if err := s.AppendPolicy(p); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i, v := range tt {
+			if found := allowed(s, v.address); !reflect.DeepEqual(found, v.allowed) {
+				t.Fatalf("%d: unexpected sources allowed for %s with policies %v: wanted %v, found %v", i, v.address, policyIDs(perm), v.allowed, found)
+			}
+		}
+	}
+}
+
+func TestMakeBlacklist_tie(t *testing.T) {
+	store.Resolver = resolver{}
+	sources := []core.Source{&mock{id: "a"}, &mock{id: "b"}}
+
+	// The patterns are equally specific: the oldest policy wins.
+	pa, err := store.NewReservedPolicy("T", "a", "ap*.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb, err := store.NewReservedPolicy("T", "b", "*pi.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		policies []store.Policy
+		allowed  []string
+	}{
+		{[]store.Policy{pa, pb}, []string{"a"}},
+		{[]store.Policy{pb, pa}, []string{"b"}},
+	} {
+		s := store.New(&storage{data: sources})
+		for _, p := range v.policies {
+			if err := s.AppendPolicy(p); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if found := allowed(s, "api.example.com:443"); !reflect.DeepEqual(found, v.allowed) {
+			t.Fatalf("Unexpected sources allowed with policies %v: wanted %v, found %v", policyIDs(v.policies), v.allowed, found)
+		}
+	}
+}
+
+func TestApplyPolicies_conflict(t *testing.T) {
+	store.Resolver = resolver{}
+	reserve := func(id, target string) store.Policy {
+		p, err := store.NewReservedPolicy("T", id, target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	avoid := func(id, target string) store.Policy {
+		p, err := store.NewAvoidPolicy("T", id, target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	tt := []struct {
+		existing store.Policy
+		added    store.Policy
+		conflict bool
+	}{
+		{reserve("a", "10.8.3.0/24"), reserve("b", "10.8.3.0/24"), true},
+		{reserve("a", "Example.com:443"), reserve("b", "example.COM.:443"), true},
+		{reserve("a", "10.8.3.0/24"), avoid("a", "10.8.3.0/24"), true},
+		{avoid("a", "10.8.3.0/24"), reserve("a", "10.8.3.0/24"), true},
+		{reserve("a", "10.8.3.0/24"), avoid("b", "10.8.3.0/24"), false},
+		{avoid("a", "10.8.3.0/24"), avoid("b", "10.8.3.0/24"), false},
+		{reserve("a", "10.8.3.0/24"), reserve("b", "10.8.3.0/25"), false},
+		{reserve("a", "example.com:443"), reserve("b", "example.com:80"), false},
+	}
+
+	for i, v := range tt {
+		s := store.New(&storage{})
+		if err := s.AppendPolicy(v.existing); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		err := s.AppendPolicy(v.added)
+		if _, ok := err.(*store.ConflictError); ok != v.conflict {
+			t.Fatalf("%d: unexpected error adding %s after %s: %v", i, v.added.ID(), v.existing.ID(), err)
+		}
+		if n := len(s.GetPoliciesSnapshot()); v.conflict && n != 1 {
+			t.Fatalf("%d: conflicting policy was stored", i)
+		}
+	}
+}
+
+func policyIDs(policies []store.Policy) []string {
+	acc := make([]string, len(policies))
+	for i, p := range policies {
+		acc[i] = p.ID()
+	}
+	return acc
+}
+
 func TestShouldAccept(t *testing.T) {
 	s := store.New(&storage{})
 	id0 := "foo"
@@ -243,7 +467,7 @@ func TestShouldAccept(t *testing.T) {
 	}
 
 	// Remove block on bar and check again
-	s.DelPolicy(pid1)
+	s.DelPolicy("T", pid1)
 	ok, p = s.ShouldAccept(id1, target)
 	if !ok {
 		t.Fatalf("Source %s was not accepted, even though it should have", id1)
@@ -285,13 +509,152 @@ func TestDelPolicy(t *testing.T) {
 	}
 
 	// Now remove the policy.
-	s.DelPolicy("foo")
+	s.DelPolicy("T", "foo")
 	if len(s.GetPoliciesSnapshot()) != 0 {
 		t.Fatalf("Unexpected policies count: wanted 0, found %+v", s.GetPoliciesSnapshot())
 	}
 
 }
 
+func TestPins(t *testing.T) {
+	s := store.New(&storage{
+		data: []core.Source{},
+	})
+	if _, err := s.AddPin("T", "10.0.0.0/33", "foo"); err == nil {
+		t.Fatalf("Expected error adding pin with invalid target")
+	}
+	if _, err := s.AddPin("T", "*.example.com", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddPin("T", "api.example.com", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := s.QueryPin("api.example.com"); !ok || id != "foo" {
+		t.Fatalf("Unexpected pin: wanted foo, found %v (%v)", id, ok)
+	}
+
+	// Replace the first pin.
+	if _, err := s.AddPin("T", "*.example.com", "baz"); err != nil {
+		t.Fatal(err)
+	}
+	if pins := s.GetPinsSnapshot(); len(pins) != 2 || pins[0].SourceID != "baz" {
+		t.Fatalf("Unexpected pins: %+v", pins)
+	}
+
+	if err := s.DelPin("*.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DelPin("*.example.com"); err == nil {
+		t.Fatalf("Expected error deleting missing pin")
+	}
+	if id, ok := s.QueryPin("api.example.com"); !ok || id != "bar" {
+		t.Fatalf("Unexpected pin: wanted bar, found %v (%v)", id, ok)
+	}
+	if _, ok := s.QueryPin("example.com"); ok {
+		t.Fatalf("Unexpected pin for example.com")
+	}
+}
+
+func TestPins_normalize(t *testing.T) {
+	s := store.New(&storage{
+		data: []core.Source{},
+	})
+	if _, err := s.AddPin("T", "API.example.com.:443", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddPin("T", "api.example.com:443", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	if pins := s.GetPinsSnapshot(); len(pins) != 1 || pins[0].Target != "api.example.com:443" || pins[0].SourceID != "bar" {
+		t.Fatalf("Unexpected pins: %+v", pins)
+	}
+	if _, ok := s.QueryPin("api.example.com:80"); ok {
+		t.Fatalf("Unexpected pin for api.example.com:80")
+	}
+	if err := s.DelPin("Api.Example.com:443"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPins_file(t *testing.T) {
+	dir, err := ioutil.TempDir("", "booster-pins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pins.json")
+
+	s := store.New(&storage{
+		data: []core.Source{},
+	})
+	if err := s.SetPinsFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddPin("T", "*.example.com", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddPin("T", "api.example.org:443", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DelPin("*.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fresh store, as after a restart.
+	s = store.New(&storage{
+		data: []core.Source{},
+	})
+	if err := s.SetPinsFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if pins := s.GetPinsSnapshot(); len(pins) != 1 || pins[0].Target != "api.example.org:443" || pins[0].Issuer != "T" {
+		t.Fatalf("Unexpected pins: %+v", pins)
+	}
+	if id, ok := s.QueryPin("api.example.org:443"); !ok || id != "bar" {
+		t.Fatalf("Unexpected pin: wanted bar, found %v (%v)", id, ok)
+	}
+
+	// Pins that cannot be saved are not applied.
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddPin("T", "example.net", "foo"); err == nil {
+		t.Fatalf("Expected error saving pins")
+	}
+	if pins := s.GetPinsSnapshot(); len(pins) != 1 {
+		t.Fatalf("Unexpected pins: %+v", pins)
+	}
+}
+
+func TestChanged(t *testing.T) {
+	s := store.New(&storage{
+		data: []core.Source{},
+	})
+	isClosed := func(c <-chan struct{}) bool {
+		select {
+		case <-c:
+			return true
+		default:
+			return false
+		}
+	}
+
+	c := s.Changed()
+	if isClosed(c) {
+		t.Fatalf("Changed channel closed before any change")
+	}
+	s.Put(&mock{id: "foo"})
+	if !isClosed(c) {
+		t.Fatalf("Changed channel not closed after Put")
+	}
+
+	c = s.Changed()
+	s.AppendPolicy(store.NewBlockPolicy("T", "foo"))
+	if !isClosed(c) {
+		t.Fatalf("Changed channel not closed after AppendPolicy")
+	}
+}
+
 func TestGetPoliciesSnapshot(t *testing.T) {
 	s := store.New(&storage{
 		data: []core.Source{},
@@ -314,6 +677,167 @@ func TestGetPoliciesSnapshot(t *testing.T) {
 	}
 }
 
+func TestGetSourcesSnapshot(t *testing.T) {
+	s0 := &mock{id: "s0"}
+	s1 := &counter{mock: mock{id: "s1"}, conns: 2}
+	s := store.New(&storage{data: []core.Source{s0, s1}})
+
+	find := func(id string) *store.SourceRecord {
+		for _, v := range s.GetSourcesSnapshot() {
+			if v.ID == id {
+				return v
+			}
+		}
+		t.Fatalf("No record found for source %s", id)
+		return nil
+	}
+
+	if r := find(s0.ID()); r.Name != s0.ID() || r.OpenConns != -1 || r.Blocked {
+		t.Fatalf("Unexpected record for %s: %+v", s0.ID(), r)
+	}
+	if r := find(s1.ID()); r.OpenConns != 2 {
+		t.Fatalf("Unexpected open connections for %s: wanted 2, found %d", s1.ID(), r.OpenConns)
+	}
+
+	s1.conns = 3
+	if r := find(s1.ID()); r.OpenConns != 3 {
+		t.Fatalf("Unexpected open connections for %s: wanted 3, found %d", s1.ID(), r.OpenConns)
+	}
+
+	p := store.NewBlockPolicy("T", s0.ID())
+	s.AppendPolicy(p)
+	if r := find(s0.ID()); !r.Blocked {
+		t.Fatalf("Source %s should be reported as blocked: %+v", s0.ID(), r)
+	}
+	if r := find(s1.ID()); r.Blocked {
+		t.Fatalf("Source %s should not be reported as blocked: %+v", s1.ID(), r)
+	}
+
+	s.DelPolicy("T", p.ID())
+	if r := find(s0.ID()); r.Blocked {
+		t.Fatalf("Source %s should not be reported as blocked: %+v", s0.ID(), r)
+	}
+}
+
+func TestGetSourcesSnapshot_lastCheck(t *testing.T) {
+	at := time.Now()
+	s0 := &checked{mock: mock{id: "s0"}}
+	s1 := &checked{mock: mock{id: "s1"}, at: at, latency: 12 * time.Millisecond}
+	s := store.New(&storage{data: []core.Source{s0, s1}})
+
+	for _, v := range s.GetSourcesSnapshot() {
+		switch v.ID {
+		case s0.ID():
+			if v.LastCheck != nil || v.CheckLatency != 0 {
+				t.Fatalf("Unexpected check for unchecked source %s: %+v", v.ID, v)
+			}
+		case s1.ID():
+			if v.LastCheck == nil || !v.LastCheck.Equal(at) || v.CheckLatency != s1.latency {
+				t.Fatalf("Unexpected check for source %s: %+v", v.ID, v)
+			}
+		}
+	}
+}
+
+func TestApplyPolicies(t *testing.T) {
+	s := store.New(&storage{})
+	reject := func(id, target string) bool { return false }
+	s.AppendPolicy(&store.GenPolicy{Name: "foo", AcceptFunc: reject})
+
+	// None of the changes should be applied if one of them fails.
+	err := s.ApplyPolicies("T", []string{"foo", "bar"}, &store.GenPolicy{Name: "baz", AcceptFunc: reject})
+	if err == nil {
+		t.Fatal("Unexpected nil error while removing a missing policy")
+	}
+	err = s.ApplyPolicies("T", []string{"foo"}, &store.GenPolicy{Name: "baz", AcceptFunc: reject}, &store.GenPolicy{Name: "baz", AcceptFunc: reject})
+	if err == nil {
+		t.Fatal("Unexpected nil error while adding duplicate policies")
+	}
+	if pl := s.GetPoliciesSnapshot(); len(pl) != 1 || pl[0].ID() != "foo" {
+		t.Fatalf("Unexpected policies: wanted [foo], found %+v", pl)
+	}
+
+	if err := s.ApplyPolicies("T", []string{"foo"}, &store.GenPolicy{Name: "baz", AcceptFunc: reject}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if pl := s.GetPoliciesSnapshot(); len(pl) != 1 || pl[0].ID() != "baz" {
+		t.Fatalf("Unexpected policies: wanted [baz], found %+v", pl)
+	}
+}
+
+// Policy evaluation must never observe a half applied mutation: it
+// sees either the old policy set or the new one.
+func TestApplyPolicies_isolation(t *testing.T) {
+	s0 := &mock{id: "s0"}
+	s1 := &mock{id: "s1"}
+	s := store.New(&storage{data: []core.Source{s0, s1}})
+
+	block := func(name, id string) store.Policy {
+		return &store.GenPolicy{
+			Name:       name,
+			AcceptFunc: func(src, target string) bool { return src != id },
+		}
+	}
+	s.AppendPolicy(block("block_s0", s0.ID()))
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			// Flip between two mutually exclusive policies.
+			if i%2 == 0 {
+				s.ApplyPolicies("T", []string{"block_s0"}, block("block_s1", s1.ID()))
+			} else {
+				s.ApplyPolicies("T", []string{"block_s1"}, block("block_s0", s0.ID()))
+			}
+		}
+	}()
+
+	errc := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if bl := s.MakeBlacklist("host:port"); len(bl) != 1 {
+					errc <- fmt.Errorf("Unexpected blacklist content: wanted exactly one source, found %+v", bl)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case err := <-errc:
+		t.Fatal(err)
+	default:
+	}
+}
+
+func BenchmarkMakeBlacklist(b *testing.B) {
+	s := store.New(&storage{data: []core.Source{&mock{id: "s0"}, &mock{id: "s1"}}})
+	s.AppendPolicy(&store.GenPolicy{
+		Name:       "block_s0",
+		AcceptFunc: func(id, target string) bool { return id != "s0" },
+	})
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.MakeBlacklist("host:port")
+		}
+	})
+}
+
 type mock struct {
 	id     string
 	active bool
@@ -338,6 +862,27 @@ func (s *mock) String() string {
 	return s.ID()
 }
 
+// counter is a mock that reports its open connections.
+type counter struct {
+	mock
+	conns int
+}
+
+func (s *counter) Len() int {
+	return s.conns
+}
+
+// checked is a mock that reports its last connection check.
+type checked struct {
+	mock
+	at      time.Time
+	latency time.Duration
+}
+
+func (s *checked) LastCheck() (time.Time, time.Duration) {
+	return s.at, s.latency
+}
+
 type storage struct {
 	index int // tells which source should be returned
 	data  []core.Source
@@ -366,6 +911,10 @@ func (s *storage) Del(ss ...core.Source) {
 	s.data = filtered
 }
 
+func (s *storage) ReplaceAll(ss ...core.Source) {
+	s.data = ss
+}
+
 func (s *storage) Len() int {
 	return len(s.data)
 }
@@ -0,0 +1,215 @@
+// Copyright © 2019 KIM KeepInMind GmbH/srl
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package testutil provides helpers that are shared by the tests
+// of the other booster packages.
+package testutil
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/booster-proj/booster/core"
+)
+
+// FakeClock is a core.Clock implementation whose time only moves
+// forward when Advance is called. Use NewFakeClock to create one.
+type FakeClock struct {
+	mux  sync.Mutex
+	cond *sync.Cond
+
+	now     time.Time
+	seq     int
+	waiters []*waiter
+}
+
+type waiter struct {
+	deadline time.Time
+	seq      int           // creation order, breaks deadline ties.
+	period   time.Duration // > 0 for tickers.
+	c        chan time.Time
+}
+
+// NewFakeClock returns a FakeClock whose current time is `now`.
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.cond = sync.NewCond(&c.mux)
+	return c
+}
+
+// Now implements core.Clock.
+func (c *FakeClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return c.now
+}
+
+// After implements core.Clock.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// NewTimer implements core.Clock.
+func (c *FakeClock) NewTimer(d time.Duration) core.Timer {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return &fakeTimer{c: c, w: c.add(d, 0)}
+}
+
+// NewTicker implements core.Clock.
+func (c *FakeClock) NewTicker(d time.Duration) core.Ticker {
+	if d <= 0 {
+		panic("testutil: non-positive interval for NewTicker")
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return &fakeTicker{c: c, w: c.add(d, d)}
+}
+
+// Advance moves the clock forward by `d`, firing, in order, each timer
+// and ticker whose deadline is reached. Timers with the same deadline
+// fire in the order they were created.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	end := c.now.Add(d)
+	for {
+		sort.SliceStable(c.waiters, func(i, j int) bool {
+			a, b := c.waiters[i], c.waiters[j]
+			if a.deadline.Equal(b.deadline) {
+				return a.seq < b.seq
+			}
+			return a.deadline.Before(b.deadline)
+		})
+		if len(c.waiters) == 0 || c.waiters[0].deadline.After(end) {
+			break
+		}
+
+		w := c.waiters[0]
+		c.now = w.deadline
+		select {
+		case w.c <- c.now:
+		default:
+			// Drop the tick like time.Ticker does with slow receivers.
+		}
+
+		c.remove(w)
+		if w.period > 0 {
+			w.deadline = w.deadline.Add(w.period)
+			c.seq++
+			w.seq = c.seq
+			c.waiters = append(c.waiters, w)
+		}
+	}
+	c.now = end
+	c.cond.Broadcast()
+}
+
+// BlockUntil blocks until at least `n` timers or tickers are waiting
+// for the clock to be advanced.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
+
+// Waiters returns the number of timers and tickers that are waiting
+// for the clock to be advanced.
+func (c *FakeClock) Waiters() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return len(c.waiters)
+}
+
+// add registers a new waiter. Must be called with the lock held.
+func (c *FakeClock) add(d, period time.Duration) *waiter {
+	c.seq++
+	w := &waiter{
+		deadline: c.now.Add(d),
+		seq:      c.seq,
+		period:   period,
+		c:        make(chan time.Time, 1),
+	}
+	c.waiters = append(c.waiters, w)
+	c.cond.Broadcast()
+	return w
+}
+
+// remove unregisters `w`, reporting whether it was registered.
+// Must be called with the lock held.
+func (c *FakeClock) remove(w *waiter) bool {
+	for i, v := range c.waiters {
+		if v == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	c *FakeClock
+	w *waiter
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.w.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mux.Lock()
+	defer t.c.mux.Unlock()
+
+	return t.c.remove(t.w)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.c.mux.Lock()
+	defer t.c.mux.Unlock()
+
+	active := t.c.remove(t.w)
+	t.c.seq++
+	t.w.deadline = t.c.now.Add(d)
+	t.w.seq = t.c.seq
+	t.c.waiters = append(t.c.waiters, t.w)
+	t.c.cond.Broadcast()
+	return active
+}
+
+type fakeTicker struct {
+	c *FakeClock
+	w *waiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.w.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.c.mux.Lock()
+	defer t.c.mux.Unlock()
+
+	t.c.remove(t.w)
+}
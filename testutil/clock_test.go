@@ -0,0 +1,129 @@
+// Copyright © 2019 KIM KeepInMind GmbH/srl
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package testutil_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/booster-proj/booster/testutil"
+)
+
+var epoch = time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func TestFakeClock_timers(t *testing.T) {
+	c := testutil.NewFakeClock(epoch)
+
+	t0 := c.NewTimer(2 * time.Second)
+	t1 := c.NewTimer(time.Second)
+	t2 := c.After(time.Second)
+
+	c.Advance(500 * time.Millisecond)
+	select {
+	case <-t1.C():
+		t.Fatal("Timer fired before its deadline")
+	default:
+	}
+
+	c.Advance(time.Second)
+	for i, ch := range []<-chan time.Time{t1.C(), t2} {
+		select {
+		case now := <-ch:
+			if want := epoch.Add(time.Second); !now.Equal(want) {
+				t.Fatalf("%d: Unexpected fire time: wanted %v, found %v", i, want, now)
+			}
+		default:
+			t.Fatalf("%d: Timer did not fire", i)
+		}
+	}
+	if !t0.Stop() {
+		t.Fatal("Stop reported an inactive timer, but it should not have fired yet")
+	}
+
+	c.Advance(time.Hour)
+	select {
+	case <-t0.C():
+		t.Fatal("Stopped timer fired")
+	default:
+	}
+	if now := c.Now(); !now.Equal(epoch.Add(time.Hour + 1500*time.Millisecond)) {
+		t.Fatalf("Unexpected clock time: %v", now)
+	}
+}
+
+func TestFakeClock_reset(t *testing.T) {
+	c := testutil.NewFakeClock(epoch)
+
+	tm := c.NewTimer(time.Second)
+	tm.Reset(3 * time.Second)
+	c.Advance(2 * time.Second)
+	select {
+	case <-tm.C():
+		t.Fatal("Timer fired before its reset deadline")
+	default:
+	}
+
+	c.Advance(time.Second)
+	select {
+	case <-tm.C():
+	default:
+		t.Fatal("Timer did not fire")
+	}
+}
+
+func TestFakeClock_ticker(t *testing.T) {
+	c := testutil.NewFakeClock(epoch)
+	tk := c.NewTicker(time.Second)
+
+	for i := 1; i <= 3; i++ {
+		c.Advance(time.Second)
+		select {
+		case now := <-tk.C():
+			if want := epoch.Add(time.Duration(i) * time.Second); !now.Equal(want) {
+				t.Fatalf("%d: Unexpected tick time: wanted %v, found %v", i, want, now)
+			}
+		default:
+			t.Fatalf("%d: Ticker did not tick", i)
+		}
+	}
+
+	tk.Stop()
+	c.Advance(time.Second)
+	select {
+	case <-tk.C():
+		t.Fatal("Stopped ticker ticked")
+	default:
+	}
+}
+
+func TestFakeClock_blockUntil(t *testing.T) {
+	c := testutil.NewFakeClock(epoch)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-c.After(time.Minute)
+	}()
+
+	c.BlockUntil(1)
+	c.Advance(time.Minute)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Waiter was not released")
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright © 2019 KIM KeepInMind GmbH/srl
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/booster-proj/booster/core"
+	"github.com/booster-proj/booster/store"
+)
+
+// storeSource is the core.Source used by TestStore. It counts how many
+// times it gets closed.
+type storeSource struct {
+	id     string
+	closed int32
+}
+
+func (s *storeSource) ID() string { return s.id }
+func (s *storeSource) Close() error {
+	atomic.AddInt32(&s.closed, 1)
+	return nil
+}
+func (s *storeSource) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return nil, fmt.Errorf("store source %s: dial not supported", s.id)
+}
+
+func (s *storeSource) isClosed() bool {
+	return atomic.LoadInt32(&s.closed) > 0
+}
+
+func storeSources(ids ...string) []core.Source {
+	acc := make([]core.Source, len(ids))
+	for i, v := range ids {
+		acc[i] = &storeSource{id: v}
+	}
+	return acc
+}
+
+func storedIDs(s store.Store) []string {
+	var acc []string
+	s.Do(func(src core.Source) {
+		acc = append(acc, src.ID())
+	})
+	sort.Strings(acc)
+	return acc
+}
+
+func equalIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestStore checks that the store.Store implementations returned by
+// `newStore` fulfill the contract expected by store.SourceStore:
+//   - Put adds sources, Del removes and closes them, Len and Do
+//     reflect the sources stored.
+//   - Get returns one of the sources stored that is not blacklisted,
+//     or an error if there is none.
+//   - ReplaceAll swaps the content in one step: concurrent readers
+//     never observe a mix of the old and new set, kept sources are
+//     not closed and removed ones are.
+//   - All methods are safe to use concurrently, run the suite with
+//     -race to check it.
+//
+// Each check runs as a subtest on a new store.
+func TestStore(t *testing.T, newStore func() store.Store) {
+	t.Run("PutDel", func(t *testing.T) { testStorePutDel(t, newStore()) })
+	t.Run("Get", func(t *testing.T) { testStoreGet(t, newStore()) })
+	t.Run("ReplaceAll", func(t *testing.T) { testStoreReplaceAll(t, newStore()) })
+	t.Run("ReplaceAllAtomic", func(t *testing.T) { testStoreReplaceAllAtomic(t, newStore()) })
+	t.Run("Concurrent", func(t *testing.T) { testStoreConcurrent(t, newStore()) })
+}
+
+func testStorePutDel(t *testing.T, s store.Store) {
+	if n := s.Len(); n != 0 {
+		t.Fatalf("Unexpected Len of new store: wanted 0, found %d", n)
+	}
+
+	ss := storeSources("a", "b", "c")
+	s.Put(ss...)
+	if n := s.Len(); n != 3 {
+		t.Fatalf("Unexpected Len after Put: wanted 3, found %d", n)
+	}
+	if ids := storedIDs(s); !equalIDs(ids, []string{"a", "b", "c"}) {
+		t.Fatalf("Unexpected content after Put: %v", ids)
+	}
+
+	s.Del(ss[1])
+	if ids := storedIDs(s); !equalIDs(ids, []string{"a", "c"}) {
+		t.Fatalf("Unexpected content after Del: %v", ids)
+	}
+	if !ss[1].(*storeSource).isClosed() {
+		t.Fatalf("Source removed with Del was not closed")
+	}
+	if ss[0].(*storeSource).isClosed() || ss[2].(*storeSource).isClosed() {
+		t.Fatalf("Source kept after Del was closed")
+	}
+
+	// Put and Del with no sources are no-ops.
+	s.Put()
+	s.Del()
+	if n := s.Len(); n != 2 {
+		t.Fatalf("Unexpected Len: wanted 2, found %d", n)
+	}
+}
+
+func testStoreGet(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	if _, err := s.Get(ctx); err == nil {
+		t.Fatalf("Expected error from Get on empty store")
+	}
+
+	ss := storeSources("a", "b")
+	s.Put(ss...)
+	for i := 0; i < 4; i++ {
+		src, err := s.Get(ctx, ss[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if src.ID() != "b" {
+			t.Fatalf("Get returned %v, which is blacklisted", src.ID())
+		}
+	}
+	if _, err := s.Get(ctx, ss...); err == nil {
+		t.Fatalf("Expected error from Get with every source blacklisted")
+	}
+}
+
+func testStoreReplaceAll(t *testing.T, s store.Store) {
+	old := storeSources("a", "b")
+	s.Put(old...)
+
+	s.ReplaceAll(append([]core.Source{old[1]}, storeSources("c")...)...)
+	if ids := storedIDs(s); !equalIDs(ids, []string{"b", "c"}) {
+		t.Fatalf("Unexpected content after ReplaceAll: %v", ids)
+	}
+	if !old[0].(*storeSource).isClosed() {
+		t.Fatalf("Source removed with ReplaceAll was not closed")
+	}
+	if old[1].(*storeSource).isClosed() {
+		t.Fatalf("Source kept by ReplaceAll was closed")
+	}
+
+	s.ReplaceAll()
+	if n := s.Len(); n != 0 {
+		t.Fatalf("Unexpected Len after ReplaceAll with no sources: wanted 0, found %d", n)
+	}
+}
+
+func testStoreReplaceAllAtomic(t *testing.T, s store.Store) {
+	fixed := storeSources("a", "b")
+	x, y := storeSources("x")[0], storeSources("y")[0]
+	s.Put(append(fixed, x)...)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if i%2 == 0 {
+				s.ReplaceAll(append(fixed, y)...)
+			} else {
+				s.ReplaceAll(append(fixed, x)...)
+			}
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		ids := storedIDs(s)
+		if len(ids) != 3 || ids[0] != "a" || ids[1] != "b" {
+			close(done)
+			wg.Wait()
+			t.Fatalf("ReplaceAll observed half way through: %v", ids)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func testStoreConcurrent(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				src := &storeSource{id: fmt.Sprintf("%d-%d", i, j)}
+				s.Put(src)
+				s.Get(ctx)
+				s.Len()
+				s.Do(func(core.Source) {})
+				s.Del(src)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := s.Len(); n != 0 {
+		t.Fatalf("Unexpected Len: wanted 0, found %d", n)
+	}
+}
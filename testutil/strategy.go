@@ -0,0 +1,128 @@
+// Copyright © 2019 KIM KeepInMind GmbH/srl
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package testutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/booster-proj/booster/core"
+)
+
+// StrategyTimeout is the maximum amount of time a strategy can take to
+// pick a source, or to handle an outcome, in TestStrategy. It matches
+// the default PickTimeout of core.Balancer.
+var StrategyTimeout = core.DefaultPickTimeout
+
+func strategyCandidates(n int) []core.SourceRecord {
+	acc := make([]core.SourceRecord, n)
+	for i := range acc {
+		acc[i] = core.SourceRecord{
+			ID:           fmt.Sprintf("src%d", i),
+			OpenConns:    i,
+			LastCheck:    time.Now(),
+			CheckLatency: time.Duration(i) * time.Millisecond,
+		}
+	}
+	return acc
+}
+
+// TestStrategy checks that `s` fulfills the requirements of a
+// core.Strategy: with different sets of candidates it has to pick,
+// without panicking and within StrategyTimeout, one of the candidates,
+// and it has to handle the outcomes reported as quickly.
+func TestStrategy(t *testing.T, s core.Strategy) {
+	t.Helper()
+	ctx := context.Background()
+	for _, n := range []int{1, 2, 10} {
+		candidates := strategyCandidates(n)
+		ids := make(map[string]bool, n)
+		for _, v := range candidates {
+			ids[v.ID] = true
+		}
+
+		for i := 0; i < 2*n; i++ {
+			flow := core.FlowDescriptor{Network: "tcp4", Target: fmt.Sprintf("host%d:443", i)}
+			id, err := callStrategy(ctx, s, candidates, flow)
+			if err != nil {
+				t.Fatalf("%d candidates, call %d: %v", n, i, err)
+			}
+			if !ids[id] {
+				t.Fatalf("%d candidates, call %d: strategy returned %q, which is not a candidate", n, i, id)
+			}
+
+			outcomes := []core.Outcome{
+				{SourceID: id, Flow: flow, DialTime: time.Millisecond},
+				{SourceID: id, Flow: flow, Err: errors.New("connection refused")},
+				{SourceID: id, Flow: flow, Closed: true, Sent: 1024, Received: 4096, Duration: time.Second},
+			}
+			for _, o := range outcomes {
+				if err := callReport(s, o); err != nil {
+					t.Fatalf("%d candidates, call %d: %v", n, i, err)
+				}
+			}
+		}
+	}
+}
+
+func callStrategy(ctx context.Context, s core.Strategy, candidates []core.SourceRecord, flow core.FlowDescriptor) (id string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("strategy panicked: %v", r)
+		}
+	}()
+
+	start := time.Now()
+	id, err = s.Pick(ctx, candidates, flow)
+	if err != nil {
+		return "", fmt.Errorf("strategy returned error: %v", err)
+	}
+	if d := time.Since(start); d > StrategyTimeout {
+		return "", fmt.Errorf("strategy took %v to pick, more than %v", d, StrategyTimeout)
+	}
+	return id, nil
+}
+
+func callReport(s core.Strategy, o core.Outcome) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("strategy panicked handling outcome: %v", r)
+		}
+	}()
+
+	start := time.Now()
+	s.Report(o)
+	if d := time.Since(start); d > StrategyTimeout {
+		return fmt.Errorf("strategy took %v to handle an outcome, more than %v", d, StrategyTimeout)
+	}
+	return nil
+}
+
+// BenchmarkStrategy measures the time `s` takes to pick a source among
+// 10 candidates.
+func BenchmarkStrategy(b *testing.B, s core.Strategy) {
+	candidates := strategyCandidates(10)
+	flow := core.FlowDescriptor{Network: "tcp4", Target: "example.com:443"}
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Pick(ctx, candidates, flow)
+	}
+}